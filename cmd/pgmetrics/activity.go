@@ -0,0 +1,143 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Show only these many rows in each of the activity sample tables.
+const activityTopN = 10
+
+// sampleCount is the total count of samples that have the same key.
+type sampleCount struct {
+	key   string
+	count int
+}
+
+// rollupSamples groups the sample items by the key returned by keyfn and
+// returns the counts, largest first.
+func rollupSamples(items []pgmetrics.ActivitySampleItem,
+	keyfn func(*pgmetrics.ActivitySampleItem) string) (out []sampleCount) {
+	m := make(map[string]int)
+	for i := range items {
+		m[keyfn(&items[i])] += items[i].Count
+	}
+	for k, v := range m {
+		out = append(out, sampleCount{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].count != out[j].count {
+			return out[i].count > out[j].count
+		}
+		return out[i].key < out[j].key
+	})
+	return
+}
+
+// fmtSampleWait returns the wait event of the item as "type: event". Active
+// backends that are not waiting are on CPU.
+func fmtSampleWait(item *pgmetrics.ActivitySampleItem) string {
+	if len(item.WaitEventType) == 0 {
+		if item.State == "active" {
+			return "CPU"
+		}
+		return "(none)"
+	}
+	if len(item.WaitEvent) == 0 {
+		return item.WaitEventType
+	}
+	return item.WaitEventType + ": " + item.WaitEvent
+}
+
+func reportActivitySamples(fd io.Writer, result *pgmetrics.Model) {
+	as := result.ActivitySamples
+	if as == nil || as.Samples == 0 {
+		return
+	}
+
+	var total int
+	for _, item := range as.Items {
+		total += item.Count
+	}
+	interval := time.Duration(as.Interval * float64(time.Second))
+	fmt.Fprintf(fd, `
+Active Session History:
+    Sampled From:        %s
+    Sampled Until:       %s
+    Samples:             %d, every %v
+    Avg Active Sessions: %.2f
+    Est. DB Time:        %v
+`,
+		fmtTime(as.Start),
+		fmtTime(as.End),
+		as.Samples, interval,
+		float64(total)/float64(as.Samples),
+		time.Duration(total)*interval,
+	)
+	if total == 0 {
+		return
+	}
+
+	write := func(title, header string, counts []sampleCount) {
+		fmt.Fprintf(fd, "    %s:\n", title)
+		var tw tableWriter
+		tw.add(header, "Samples", "% of Total", "Avg Sessions", "Est. Time")
+		for i, c := range counts {
+			if i == activityTopN {
+				break
+			}
+			tw.add(c.key, c.count,
+				fmt.Sprintf("%.1f", 100*float64(c.count)/float64(total)),
+				fmt.Sprintf("%.2f", float64(c.count)/float64(as.Samples)),
+				time.Duration(c.count)*interval)
+		}
+		tw.write(fd, "      ")
+	}
+
+	write("Top Waits", "Wait", rollupSamples(as.Items,
+		func(item *pgmetrics.ActivitySampleItem) string {
+			w := fmtSampleWait(item)
+			if len(item.LockMode) > 0 && item.WaitEvent == item.LockType {
+				w += " (" + item.LockMode + ")"
+			} else if len(item.LockMode) > 0 {
+				w += " (" + item.LockType + ", " + item.LockMode + ")"
+			}
+			return w
+		}))
+	write("Top Queries", "Query", rollupSamples(as.Items,
+		func(item *pgmetrics.ActivitySampleItem) string {
+			return prepQ(item.Query)
+		}))
+	write("Top Users", "User", rollupSamples(as.Items,
+		func(item *pgmetrics.ActivitySampleItem) string {
+			return item.RoleName + "/" + item.DBName
+		}))
+	write("Top Applications", "Application", rollupSamples(as.Items,
+		func(item *pgmetrics.ActivitySampleItem) string {
+			return item.ApplicationName
+		}))
+	write("By State", "State", rollupSamples(as.Items,
+		func(item *pgmetrics.ActivitySampleItem) string {
+			return item.State
+		}))
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// getRelOption returns the value of the storage parameter name from the
+// reloptions list opts.
+func getRelOption(opts []string, name string) (string, bool) {
+	for _, o := range opts {
+		if pos := strings.IndexByte(o, '='); pos > 0 && o[:pos] == name {
+			return o[pos+1:], true
+		}
+	}
+	return "", false
+}
+
+// hasAVOptions returns true if any of the autovacuum-related storage
+// parameters have been set for the table.
+func hasAVOptions(t *pgmetrics.Table) bool {
+	for _, o := range t.RelOptions {
+		if strings.HasPrefix(o, "autovacuum_") || strings.HasPrefix(o, "toast.autovacuum_") {
+			return true
+		}
+	}
+	return false
+}
+
+// avThresholds are the effective autovacuum trigger points for a table.
+type avThresholds struct {
+	enabled    bool
+	vacuumAt   int64 // vacuum when dead tuples exceed this
+	insertAt   int64 // or when inserts since vacuum exceed this, -1 if n/a
+	analyzeAt  int64 // analyze when modifications since analyze exceed this
+	vacuumDue  bool
+	analyzeDue bool
+}
+
+// getAVThresholds computes the thresholds at which autovacuum would trigger a
+// vacuum and an analyze of the table, from the global settings overridden by
+// the table's storage parameters. See relation_needs_vacanalyze() in
+// src/backend/postmaster/autovacuum.c.
+func getAVThresholds(result *pgmetrics.Model, t *pgmetrics.Table) (av avThresholds) {
+	float := func(key string) float64 {
+		if v, ok := getRelOption(t.RelOptions, key); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+				return f
+			}
+		}
+		return getSettingFloat(result, key)
+	}
+
+	av.enabled = getSetting(result, "autovacuum") != "off" &&
+		getSetting(result, "track_counts") != "off"
+	if v, ok := getRelOption(t.RelOptions, "autovacuum_enabled"); ok {
+		if b, err := strconv.ParseBool(v); err == nil && !b {
+			av.enabled = false
+		}
+	}
+
+	tuples := float64(t.NLiveTup)
+	av.vacuumAt = int64(float("autovacuum_vacuum_threshold") +
+		float("autovacuum_vacuum_scale_factor")*tuples)
+	av.analyzeAt = int64(float("autovacuum_analyze_threshold") +
+		float("autovacuum_analyze_scale_factor")*tuples)
+	av.vacuumDue = t.NDeadTup > av.vacuumAt
+
+	// from pg v13, inserts can also trigger a vacuum, unless the threshold is
+	// set to -1
+	av.insertAt = -1
+	if getVersion(result) >= 130000 &&
+		len(getSetting(result, "autovacuum_vacuum_insert_threshold")) > 0 {
+		insThreshold := getSettingFloat(result, "autovacuum_vacuum_insert_threshold")
+		if v, ok := getRelOption(t.RelOptions, "autovacuum_vacuum_insert_threshold"); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				insThreshold = f
+			}
+		}
+		if insThreshold >= 0 {
+			av.insertAt = int64(insThreshold +
+				float("autovacuum_vacuum_insert_scale_factor")*tuples)
+			av.vacuumDue = av.vacuumDue || t.NInsSinceVacuum > av.insertAt
+		}
+	}
+	av.analyzeDue = t.RelKind != "p" && t.NModSinceAnalyze > av.analyzeAt
+	return
+}
+
+func fmtInsertAt(av avThresholds) string {
+	if av.insertAt < 0 {
+		return "disabled"
+	}
+	return strconv.FormatInt(av.insertAt, 10)
+}
+
+func fmtAVStatus(av avThresholds) string {
+	var parts []string
+	if !av.enabled {
+		parts = append(parts, "autovacuum disabled")
+	}
+	if av.vacuumDue {
+		parts = append(parts, "vacuum overdue")
+	}
+	if av.analyzeDue {
+		parts = append(parts, "analyze overdue")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func reportAutovacuum(fd io.Writer, result *pgmetrics.Model) {
+	inserts := getVersion(result) >= 130000
+	var tw tableWriter
+	cols := []interface{}{"Table", "Dead Tuples", "Vacuum At"}
+	if inserts {
+		cols = append(cols, "Inserts Since Vacuum", "Insert Vacuum At")
+	}
+	tw.add(append(cols, "Mods Since Analyze", "Analyze At", "Options", "Status")...)
+	for i := range result.Tables {
+		t := &result.Tables[i]
+		if t.RelKind != "r" && t.RelKind != "m" {
+			continue
+		}
+		av := getAVThresholds(result, t)
+		status := fmtAVStatus(av)
+		if len(status) == 0 && !hasAVOptions(t) {
+			continue
+		}
+		vals := []interface{}{t.DBName + "." + t.SchemaName + "." + t.Name,
+			t.NDeadTup, av.vacuumAt}
+		if inserts {
+			vals = append(vals, t.NInsSinceVacuum, fmtInsertAt(av))
+		}
+		tw.add(append(vals, t.NModSinceAnalyze, av.analyzeAt,
+			strings.Join(t.RelOptions, ", "), status)...)
+	}
+	if len(tw.data) == 1 {
+		return
+	}
+	fmt.Fprint(fd, `
+Autovacuum Thresholds:
+`)
+	tw.write(fd, "    ")
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+func makeBlockingNode(result *pgmetrics.Model, pid int) pgmetrics.BlockingNode {
+	n := pgmetrics.BlockingNode{PID: pid}
+	if pid == 0 {
+		// pg_blocking_pids reports locks held by prepared transactions as 0
+		n.State = "prepared transaction"
+	} else if be := getBE(result, pid); be != nil {
+		n.DBName = be.DBName
+		n.RoleName = be.RoleName
+		n.ApplicationName = be.ApplicationName
+		n.ClientAddr = be.ClientAddr
+		n.State = be.State
+		n.WaitEventType = be.WaitEventType
+		n.WaitEvent = be.WaitEvent
+		n.XactStart = be.XactStart
+		n.StateChange = be.StateChange
+		n.Query = be.Query
+	}
+	for i := range result.Locks {
+		if l := &result.Locks[i]; l.PID == pid && !l.Granted {
+			n.Lock = getLockDesc(l, result)
+			break
+		}
+	}
+	return n
+}
+
+// getBlockingTree arranges the backends that are waiting for locks into trees,
+// rooted at the backends that are blocking others without themselves waiting
+// for a lock. Backends waiting on each other in a cycle (a deadlock that is
+// yet to be detected) are rooted at the lowest PID in the cycle. A backend
+// waiting for more than one other backend appears only once, under the first
+// of them.
+func getBlockingTree(result *pgmetrics.Model) (roots []pgmetrics.BlockingNode) {
+	if len(result.BlockingPIDs) == 0 {
+		return
+	}
+
+	// invert the waiter -> blockers map
+	waiters := make(map[int][]int)
+	for w, bs := range result.BlockingPIDs {
+		for _, b := range bs {
+			waiters[b] = append(waiters[b], w)
+		}
+	}
+	blockers := make([]int, 0, len(waiters))
+	for b := range waiters {
+		sort.Ints(waiters[b])
+		blockers = append(blockers, b)
+	}
+	sort.Ints(blockers)
+
+	// countBlocked returns the number of distinct backends directly or
+	// indirectly waiting for pid, including those shown under other blockers
+	countBlocked := func(pid int) int {
+		seen := map[int]bool{pid: true}
+		stack := []int{pid}
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, w := range waiters[p] {
+				if !seen[w] {
+					seen[w] = true
+					stack = append(stack, w)
+				}
+			}
+		}
+		return len(seen) - 1
+	}
+
+	visited := make(map[int]bool)
+	var build func(pid int) pgmetrics.BlockingNode
+	build = func(pid int) pgmetrics.BlockingNode {
+		n := makeBlockingNode(result, pid)
+		visited[pid] = true
+		for _, w := range waiters[pid] {
+			if !visited[w] {
+				n.Blocked = append(n.Blocked, build(w))
+			}
+		}
+		n.TotalBlocked = countBlocked(pid)
+		return n
+	}
+
+	for _, b := range blockers {
+		if len(result.BlockingPIDs[b]) == 0 {
+			roots = append(roots, build(b))
+		}
+	}
+	for _, b := range blockers {
+		if !visited[b] {
+			roots = append(roots, build(b))
+		}
+	}
+
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].TotalBlocked > roots[j].TotalBlocked
+	})
+	return
+}
+
+// fmtAge formats the time elapsed from since to now, or returns an empty
+// string if since is not set.
+func fmtAge(since, now int64) string {
+	if since <= 0 || since > now {
+		return ""
+	}
+	return (time.Duration(now-since) * time.Second).String()
+}
+
+func fmtBlockingClient(n *pgmetrics.BlockingNode) string {
+	var out string
+	if len(n.ApplicationName) > 0 {
+		out = n.ApplicationName + " "
+	}
+	out += n.RoleName
+	if len(n.ClientAddr) > 0 {
+		out += "@" + strings.TrimSuffix(strings.TrimSuffix(n.ClientAddr, "/128"), "/32")
+	}
+	if len(n.DBName) > 0 {
+		out += "/" + n.DBName
+	}
+	return out
+}
+
+// fmtBlockingState describes the state of the backend, how long it has been
+// in it, and the age of its transaction.
+func fmtBlockingState(n *pgmetrics.BlockingNode, now int64) string {
+	state := n.State
+	if len(state) == 0 {
+		state = "unknown"
+	}
+	if age := fmtAge(n.StateChange, now); len(age) > 0 {
+		state += " " + age
+	}
+	if age := fmtAge(n.XactStart, now); len(age) > 0 {
+		state += ", xact " + age
+	}
+	return state
+}
+
+func fmtBlockingLabel(n *pgmetrics.BlockingNode, now int64) string {
+	out := fmt.Sprintf("PID %d [%s]", n.PID, fmtBlockingState(n, now))
+	if len(n.Lock) > 0 {
+		out += " waiting for " + n.Lock
+	}
+	if c := fmtBlockingClient(n); len(c) > 0 {
+		out += " " + c
+	}
+	if len(n.Query) > 0 {
+		out += ": " + prepQ(n.Query)
+	}
+	return out
+}
+
+func reportBlockingTree(fd io.Writer, result *pgmetrics.Model) {
+	if len(result.BlockingTree) == 0 {
+		return
+	}
+	var blocked int
+	for _, bs := range result.BlockingPIDs {
+		if len(bs) > 0 {
+			blocked++
+		}
+	}
+	fmt.Fprintf(fd, `
+Blocking Tree:
+    Root Blockers:       %d
+    Blocked Backends:    %d
+`,
+		len(result.BlockingTree), blocked)
+
+	now := result.Metadata.At
+	var walk func(n *pgmetrics.BlockingNode, prefix, branch string)
+	walk = func(n *pgmetrics.BlockingNode, prefix, branch string) {
+		line := prefix + branch + fmtBlockingLabel(n, now)
+		if len(branch) == 0 {
+			line += fmt.Sprintf(" (root, blocks %d)", n.TotalBlocked)
+		}
+		fmt.Fprintln(fd, line)
+		switch branch {
+		case "├── ":
+			prefix += "│   "
+		case "└── ":
+			prefix += "    "
+		}
+		for i := range n.Blocked {
+			if i == len(n.Blocked)-1 {
+				walk(&n.Blocked[i], prefix, "└── ")
+			} else {
+				walk(&n.Blocked[i], prefix, "├── ")
+			}
+		}
+	}
+	for i := range result.BlockingTree {
+		walk(&result.BlockingTree[i], "    ", "")
+	}
+}
+
+func writeBlockingDOT(fd io.Writer, result *pgmetrics.Model) {
+	now := result.Metadata.At
+	nodes := make(map[int]bool)
+	edges := make(map[[2]int]bool)
+	fmt.Fprintln(fd, "digraph locks {")
+	fmt.Fprintln(fd, "  node [shape=box];")
+	var walk func(n *pgmetrics.BlockingNode, root bool)
+	walk = func(n *pgmetrics.BlockingNode, root bool) {
+		id := dotQuote(strconv.Itoa(n.PID))
+		if !nodes[n.PID] {
+			nodes[n.PID] = true
+			label := fmt.Sprintf("PID %d\n%s", n.PID, fmtBlockingState(n, now))
+			if c := fmtBlockingClient(n); len(c) > 0 {
+				label += "\n" + c
+			}
+			if len(n.Query) > 0 {
+				label += "\n" + prepQ(n.Query)
+			}
+			attrs := "label=" + dotQuote(label)
+			if root {
+				attrs += ", color=red, style=bold"
+			}
+			fmt.Fprintf(fd, "  %s [%s];\n", id, attrs)
+		}
+		for i := range n.Blocked {
+			w := &n.Blocked[i]
+			if e := [2]int{w.PID, n.PID}; !edges[e] {
+				edges[e] = true
+				// edges point from the waiter to the blocker
+				fmt.Fprintf(fd, "  %s -> %s [label=%s];\n",
+					dotQuote(strconv.Itoa(w.PID)), id, dotQuote(w.Lock))
+			}
+			walk(w, false)
+		}
+	}
+	for i := range result.BlockingTree {
+		walk(&result.BlockingTree[i], true)
+	}
+	fmt.Fprintln(fd, "}")
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Show only these many relations in the buffer cache report.
+const bufferCacheTopN = 20
+
+func fmtRelKind(relkind string) string {
+	switch relkind {
+	case "r":
+		return "table"
+	case "i":
+		return "index"
+	case "S":
+		return "sequence"
+	case "t":
+		return "toast table"
+	case "m":
+		return "mat. view"
+	case "p":
+		return "partitioned table"
+	case "I":
+		return "partitioned index"
+	}
+	return relkind
+}
+
+func fmtUsageCounts(counts []int64) string {
+	parts := make([]string, len(counts))
+	for i, n := range counts {
+		parts[i] = fmt.Sprintf("%d", n)
+	}
+	return strings.Join(parts, "/")
+}
+
+func reportBufferCache(fd io.Writer, result *pgmetrics.Model) {
+	bc := result.BufferCache
+	if bc == nil || bc.Buffers == 0 {
+		return
+	}
+	blockSize := int64(getSettingInt(result, "block_size"))
+	if blockSize <= 0 {
+		blockSize = 8192
+	}
+
+	fmt.Fprintf(fd, `
+Buffer Cache:
+    Buffers:             %d (%s)
+    Used:                %d (%.1f%%)
+    Dirty:               %d (%.1f%% of used)
+`,
+		bc.Buffers, humanize.IBytes(uint64(bc.Buffers*blockSize)),
+		bc.Used, 100*safeDiv(bc.Used, bc.Buffers),
+		bc.Dirty, 100*safeDiv(bc.Dirty, bc.Used),
+	)
+
+	// histogram of usage counts
+	var tw tableWriter
+	tw.add("Usage Count", "Buffers", "% of Used")
+	for i, n := range bc.UsageCounts {
+		tw.add(i, n, fmt.Sprintf("%.1f", 100*safeDiv(n, bc.Used)))
+	}
+	tw.write(fd, "    ")
+
+	if len(bc.Relations) == 0 {
+		return
+	}
+	rels := make([]*pgmetrics.BufferCacheRelation, len(bc.Relations))
+	for i := range bc.Relations {
+		rels[i] = &bc.Relations[i]
+	}
+	sort.SliceStable(rels, func(i, j int) bool {
+		return rels[i].Buffers > rels[j].Buffers
+	})
+	if len(rels) > bufferCacheTopN {
+		rels = rels[:bufferCacheTopN]
+	}
+
+	fmt.Fprintln(fd, "    Top Relations:")
+	var tw2 tableWriter
+	tw2.add("Relation", "Kind", "Buffers", "Cached", "% of Cache",
+		"% of Relation", "Dirty", "Usage 0/1/2/3/4/5")
+	for _, r := range rels {
+		// relative to the main fork, the few buffers of the free space and
+		// visibility maps are ignored
+		var pctRel string
+		if r.Size > 0 {
+			pctRel = fmt.Sprintf("%.1f", 100*safeDiv(r.Buffers*blockSize, r.Size))
+		}
+		db := r.DBName
+		if len(db) == 0 {
+			db = "(shared)"
+		}
+		tw2.add(db+"."+r.SchemaName+"."+r.Name, fmtRelKind(r.RelKind),
+			r.Buffers, humanize.IBytes(uint64(r.Buffers*blockSize)),
+			fmt.Sprintf("%.1f", 100*safeDiv(r.Buffers, bc.Buffers)),
+			pctRel, r.Dirty, fmtUsageCounts(r.UsageCounts))
+	}
+	tw2.write(fd, "      ")
+}
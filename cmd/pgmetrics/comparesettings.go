@@ -0,0 +1,415 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/getopt"
+	"github.com/rapidloop/pgmetrics"
+)
+
+const compareUsage = `pgmetrics compare-settings compares the settings in two or more
+previously saved JSON files.
+
+Usage:
+  pgmetrics compare-settings [OPTION]... FILE FILE...
+
+Options:
+      --ignore=KEYS            do not compare the settings specified as a
+                                   comma-separated list
+      --ignore-per-host        do not compare settings that are expected to
+                                   differ between hosts, like cluster_name
+  -a, --all                    also show settings that differ only in source
+  -o, --output=FILE            write output to the specified file
+  -?, --help                   show this help, then exit
+`
+
+// perHostSettings are expected to be different between the members of a
+// cluster or between primaries and their replicas.
+var perHostSettings = []string{
+	"cluster_name", "config_file", "data_directory", "external_pid_file",
+	"hba_file", "ident_file", "hot_standby", "in_hot_standby",
+	"listen_addresses", "port", "primary_conninfo", "primary_slot_name",
+	"promote_trigger_file", "recovery_target_timeline", "restore_command",
+	"ssl_cert_file", "ssl_key_file", "synchronous_standby_names",
+	"transaction_read_only", "default_transaction_read_only",
+}
+
+// settingUnits are the units that pg_settings reports the values of these
+// settings in, for settings where the value is not self-describing.
+var settingUnits = map[string]string{
+	"shared_buffers":                      "8kB",
+	"effective_cache_size":                "8kB",
+	"temp_buffers":                        "8kB",
+	"wal_buffers":                         "8kB",
+	"backend_flush_after":                 "8kB",
+	"bgwriter_flush_after":                "8kB",
+	"checkpoint_flush_after":              "8kB",
+	"wal_writer_flush_after":              "8kB",
+	"min_parallel_table_scan_size":        "8kB",
+	"min_parallel_index_scan_size":        "8kB",
+	"min_parallel_relation_size":          "8kB",
+	"work_mem":                            "kB",
+	"maintenance_work_mem":                "kB",
+	"autovacuum_work_mem":                 "kB",
+	"logical_decoding_work_mem":           "kB",
+	"temp_file_limit":                     "kB",
+	"log_temp_files":                      "kB",
+	"max_stack_depth":                     "kB",
+	"gin_pending_list_limit":              "kB",
+	"log_rotation_size":                   "kB",
+	"wal_keep_size":                       "MB",
+	"max_slot_wal_keep_size":              "MB",
+	"statement_timeout":                   "ms",
+	"lock_timeout":                        "ms",
+	"idle_in_transaction_session_timeout": "ms",
+	"idle_session_timeout":                "ms",
+	"deadlock_timeout":                    "ms",
+	"log_min_duration_statement":          "ms",
+	"log_min_duration_sample":             "ms",
+	"log_autovacuum_min_duration":         "ms",
+	"bgwriter_delay":                      "ms",
+	"wal_writer_delay":                    "ms",
+	"autovacuum_vacuum_cost_delay":        "ms",
+	"vacuum_cost_delay":                   "ms",
+	"max_standby_archive_delay":           "ms",
+	"max_standby_streaming_delay":         "ms",
+	"wal_receiver_timeout":                "ms",
+	"wal_sender_timeout":                  "ms",
+	"wal_retrieve_retry_interval":         "ms",
+	"recovery_min_apply_delay":            "ms",
+	"tcp_user_timeout":                    "ms",
+	"checkpoint_timeout":                  "s",
+	"checkpoint_warning":                  "s",
+	"archive_timeout":                     "s",
+	"autovacuum_naptime":                  "s",
+	"authentication_timeout":              "s",
+	"wal_receiver_status_interval":        "s",
+	"tcp_keepalives_idle":                 "s",
+	"tcp_keepalives_interval":             "s",
+	"log_rotation_age":                    "min",
+	"old_snapshot_threshold":              "min",
+}
+
+// getSettingUnit returns the unit of the value of the setting key, or an
+// empty string if the value does not have a unit.
+func getSettingUnit(result *pgmetrics.Model, key string) string {
+	if s, ok := result.Settings[key]; ok && len(s.Unit) > 0 {
+		return s.Unit
+	}
+	// older models do not have the unit, use what the server would have had
+	version := getVersion(result)
+	switch key {
+	case "max_wal_size", "min_wal_size":
+		if version >= 100000 {
+			return "MB"
+		}
+		return "16MB"
+	case "wal_segment_size":
+		if version >= 110000 {
+			return "B"
+		}
+		return "8kB"
+	}
+	return settingUnits[key]
+}
+
+// settingValue is the value of a setting, normalised so that values in
+// different units can be compared.
+type settingValue struct {
+	present bool
+	isNum   bool
+	num     float64 // in bytes or milliseconds, if isNum
+	raw     string
+	display string
+	source  string
+	isDef   bool
+}
+
+// parseUnit returns the multiplier to convert a value in the given unit to
+// bytes (for memory) or milliseconds (for time).
+func parseUnit(unit string) (mult float64, ok bool) {
+	i := 0
+	for i < len(unit) && unit[i] >= '0' && unit[i] <= '9' {
+		i++
+	}
+	mult = 1
+	if i > 0 {
+		n, err := strconv.Atoi(unit[:i])
+		if err != nil {
+			return 0, false
+		}
+		mult = float64(n)
+	}
+	switch unit[i:] {
+	case "B":
+	case "kB":
+		mult *= 1 << 10
+	case "MB":
+		mult *= 1 << 20
+	case "GB":
+		mult *= 1 << 30
+	case "TB":
+		mult *= 1 << 40
+	case "us":
+		mult /= 1000
+	case "ms":
+	case "s":
+		mult *= 1000
+	case "min":
+		mult *= 60 * 1000
+	case "h":
+		mult *= 60 * 60 * 1000
+	case "d":
+		mult *= 24 * 60 * 60 * 1000
+	default:
+		return 0, false
+	}
+	return mult, true
+}
+
+// fmtTimeSetting formats a duration in milliseconds the way Postgres
+// displays time settings, using the largest unit that divides it exactly.
+func fmtTimeSetting(ms float64) string {
+	units := []struct {
+		name string
+		size float64
+	}{
+		{"d", 24 * 60 * 60 * 1000}, {"h", 60 * 60 * 1000}, {"min", 60 * 1000},
+		{"s", 1000}, {"ms", 1},
+	}
+	for _, u := range units {
+		if ms >= u.size && ms == float64(int64(ms/u.size))*u.size {
+			return fmt.Sprintf("%d%s", int64(ms/u.size), u.name)
+		}
+	}
+	return strconv.FormatFloat(ms, 'f', -1, 64) + "ms"
+}
+
+// isBootVal checks if the setting is the same as its boot value. Both are
+// in the same (base) unit, but may be formatted differently, like "0.9" and
+// "0.90".
+func isBootVal(setting, bootVal string) bool {
+	if len(bootVal) == 0 {
+		return false // not collected
+	}
+	if setting == bootVal {
+		return true
+	}
+	f1, err1 := strconv.ParseFloat(setting, 64)
+	f2, err2 := strconv.ParseFloat(bootVal, 64)
+	return err1 == nil && err2 == nil && f1 == f2
+}
+
+func getSettingValue(result *pgmetrics.Model, key string) (v settingValue) {
+	s, ok := result.Settings[key]
+	if !ok {
+		return
+	}
+	v.present = true
+	v.raw = s.Setting
+	v.display = s.Setting
+	v.source = s.Source
+	// source and boot value are omitted when the setting is at its default,
+	// else the setting may still have been explicitly set to the boot value
+	v.isDef = s.Source == "default" || len(s.Source) == 0 ||
+		isBootVal(s.Setting, s.BootVal)
+
+	if f, err := strconv.ParseFloat(s.Setting, 64); err == nil {
+		v.isNum = true
+		v.num = f
+		// values <= 0 usually have special meanings, like "disabled"
+		if unit := getSettingUnit(result, key); len(unit) > 0 && f > 0 {
+			if mult, ok := parseUnit(unit); ok {
+				v.num = f * mult
+				if strings.HasSuffix(unit, "B") {
+					v.display = fmtMemSetting(int64(v.num))
+				} else {
+					v.display = fmtTimeSetting(v.num)
+				}
+			}
+		}
+	}
+	return
+}
+
+func (v settingValue) equal(w settingValue) bool {
+	if v.present != w.present {
+		return false
+	}
+	if v.isNum && w.isNum {
+		return v.num == w.num
+	}
+	return v.raw == w.raw
+}
+
+func (v settingValue) String() string {
+	if !v.present {
+		return "-"
+	}
+	if v.isDef && len(v.source) > 0 && v.source != "default" {
+		return v.display + " (" + v.source + ", default)"
+	}
+	if v.isDef {
+		return v.display + " (default)"
+	}
+	if len(v.source) > 0 {
+		return v.display + " (" + v.source + ")"
+	}
+	return v.display
+}
+
+// compareSettings returns the keys of the settings that differ among the
+// given models. Settings that are missing from some of the models are
+// reported only if they are not at their default values where present.
+// If all is true, settings with the same value but different sources are
+// also reported.
+func compareSettings(results []*pgmetrics.Model, ignore map[string]bool, all bool) (keys []string) {
+	allKeys := make(map[string]bool)
+	for _, r := range results {
+		for k := range r.Settings {
+			if !ignore[k] {
+				allKeys[k] = true
+			}
+		}
+	}
+	for k := range allKeys {
+		vals := make([]settingValue, len(results))
+		for i, r := range results {
+			vals[i] = getSettingValue(r, k)
+		}
+		differs, missing, nonDef := false, false, false
+		for _, v := range vals {
+			if !v.present {
+				missing = true
+				continue
+			}
+			if !v.isDef {
+				nonDef = true
+			}
+		}
+		for _, v := range vals[1:] {
+			if !v.present || !vals[0].present {
+				continue
+			}
+			if !v.equal(vals[0]) || (all && v.source != vals[0].source) {
+				differs = true
+			}
+		}
+		if differs || (missing && nonDef) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func compareSettingsMain(args []string) {
+	log.SetFlags(0)
+	log.SetPrefix("pgmetrics: ")
+
+	var ignore []string
+	var ignorePerHost, all, help bool
+	var output string
+	s := getopt.New()
+	s.SetUsage(func() {
+		fmt.Fprintf(os.Stderr, "Try \"pgmetrics compare-settings --help\" for more information.\n")
+	})
+	s.SetProgram("pgmetrics compare-settings")
+	s.ListVarLong(&ignore, "ignore", 0, "")
+	s.BoolVarLong(&ignorePerHost, "ignore-per-host", 0, "").SetFlag()
+	s.BoolVarLong(&all, "all", 'a', "").SetFlag()
+	s.StringVarLong(&output, "output", 'o', "")
+	s.BoolVarLong(&help, "help", '?', "").SetFlag()
+	s.Parse(args)
+	if help {
+		fmt.Print(compareUsage)
+		os.Exit(0)
+	}
+	files := s.Args()
+	if len(files) < 2 {
+		fmt.Fprintln(os.Stderr, "at least two files are required to compare")
+		s.PrintUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	ignoreMap := make(map[string]bool)
+	for _, k := range ignore {
+		ignoreMap[k] = true
+	}
+	if ignorePerHost {
+		for _, k := range perHostSettings {
+			ignoreMap[k] = true
+		}
+	}
+
+	results := make([]*pgmetrics.Model, len(files))
+	for i, f := range files {
+		results[i] = readModel(f)
+		if len(results[i].Settings) == 0 {
+			log.Fatalf("%s: no settings found", f)
+		}
+	}
+
+	fd := io.Writer(os.Stdout)
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		fd = f
+	}
+	writeSettingsDiff(fd, files, results, compareSettings(results, ignoreMap, all))
+}
+
+func writeSettingsDiff(fd io.Writer, files []string, results []*pgmetrics.Model, keys []string) {
+	for i, f := range files {
+		fmt.Fprintf(fd, "[%d] %s: %s, PostgreSQL %s, at %s\n", i+1, f,
+			getSetting(results[i], "cluster_name"),
+			getSetting(results[i], "server_version"),
+			fmtTime(results[i].Metadata.At))
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(fd, "\nNo differences in settings.")
+		return
+	}
+	fmt.Fprintf(fd, "\n%d setting(s) differ:\n", len(keys))
+
+	var tw tableWriter
+	hdr := []interface{}{"Setting"}
+	for i, f := range files {
+		hdr = append(hdr, fmt.Sprintf("[%d] %s", i+1, filepath.Base(f)))
+	}
+	tw.add(hdr...)
+	for _, k := range keys {
+		row := []interface{}{k}
+		for _, r := range results {
+			row = append(row, getSettingValue(r, k).String())
+		}
+		tw.add(row...)
+	}
+	tw.write(fd, "    ")
+}
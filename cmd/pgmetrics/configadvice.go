@@ -0,0 +1,275 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// severities of findings, in increasing order of importance
+const (
+	sevInfo     = "info"
+	sevWarning  = "warning"
+	sevCritical = "critical"
+)
+
+func severityRank(sev string) int {
+	switch sev {
+	case sevCritical:
+		return 2
+	case sevWarning:
+		return 1
+	}
+	return 0
+}
+
+// configFinding is a single recommendation about a configuration setting.
+type configFinding struct {
+	severity  string
+	setting   string
+	current   string
+	suggested string
+	rationale string
+}
+
+// getTotalMemory returns the total RAM of the server in bytes, from the
+// system metrics or the RDS enhanced monitoring metrics. It returns 0 if
+// this is not known.
+func getTotalMemory(result *pgmetrics.Model) int64 {
+	if s := result.System; s != nil {
+		if total := s.MemUsed + s.MemFree + s.MemBuffers + s.MemCached + s.MemSlab; total > 0 {
+			return total
+		}
+	}
+	if result.RDS != nil {
+		if mem, ok := result.RDS.Enhanced["memory"].(map[string]interface{}); ok {
+			if total, ok := mem["total"].(float64); ok && total > 0 {
+				return int64(total) * 1024 // reported in kB
+			}
+		}
+	}
+	return 0
+}
+
+// getWALRate returns the rate at which WAL is being generated, in bytes per
+// second. This is taken from the RDS metrics if present, else estimated from
+// the count of archived WAL files. It returns 0 if this is not known.
+func getWALRate(result *pgmetrics.Model) float64 {
+	if result.RDS != nil {
+		if v, ok := result.RDS.Basic["TransactionLogsGeneration"]; ok && v > 0 {
+			return v
+		}
+	}
+	a := &result.WALArchiving
+	if a.ArchivedCount > 0 && a.StatsReset > 0 && result.Metadata.At > a.StatsReset {
+		total := float64(a.ArchivedCount) * float64(getWALSegmentSize(result))
+		return total / float64(result.Metadata.At-a.StatsReset)
+	}
+	return 0
+}
+
+// getWALSegmentSize returns the size of a WAL segment file in bytes. Before
+// v11, the setting is in units of WAL blocks.
+func getWALSegmentSize(result *pgmetrics.Model) int64 {
+	v := int64(getSettingInt(result, "wal_segment_size"))
+	if v <= 0 {
+		return 16 * 1024 * 1024
+	}
+	if getVersion(result) < 110000 {
+		if bs := int64(getSettingInt(result, "wal_block_size")); bs > 0 {
+			return v * bs
+		}
+		return v * 8192
+	}
+	return v
+}
+
+// getMaxWalSizeBytes returns the value of max_wal_size in bytes, or 0 if the
+// server does not have this setting.
+func getMaxWalSizeBytes(result *pgmetrics.Model) int64 {
+	v := int64(getSettingInt(result, "max_wal_size"))
+	if version := getVersion(result); version >= 100000 {
+		return v * 1024 * 1024
+	} else if version >= 90500 {
+		return v * 16 * 1024 * 1024
+	}
+	return 0
+}
+
+// fmtMemSetting formats a size in bytes the way Postgres displays memory
+// settings, using the largest unit that divides it exactly.
+func fmtMemSetting(b int64) string {
+	units := []struct {
+		name string
+		size int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"kB", 1 << 10},
+	}
+	for _, u := range units {
+		if b >= u.size && b%u.size == 0 {
+			return fmt.Sprintf("%d%s", b/u.size, u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", b)
+}
+
+// roundMem rounds a suggested size to a whole number of GB, or MB if it is
+// less than a GB, so that it looks like something a human would configure.
+func roundMem(b int64) int64 {
+	unit := int64(1 << 20)
+	if b >= 1<<30 {
+		unit = 1 << 30
+	}
+	if r := (b + unit/2) / unit * unit; r > 0 {
+		return r
+	}
+	return unit
+}
+
+// getConfigAdvice applies a set of rules of thumb to the configuration
+// settings, in the light of the system resources and activity.
+func getConfigAdvice(result *pgmetrics.Model) (out []configFinding) {
+	if len(result.Settings) == 0 {
+		return
+	}
+	add := func(sev, setting, current, suggested, rationale string) {
+		out = append(out, configFinding{sev, setting, current, suggested, rationale})
+	}
+	version := getVersion(result)
+	ram := getTotalMemory(result)
+	blockSize := int64(getBlockSize(result))
+
+	// shared_buffers should be about 25% of RAM
+	sb := int64(getSettingInt(result, "shared_buffers")) * blockSize
+	if ram > 0 && sb > 0 {
+		pct := 100 * float64(sb) / float64(ram)
+		if pct < 15 {
+			add(sevWarning, "shared_buffers", fmtMemSetting(sb), fmtMemSetting(roundMem(ram/4)),
+				fmt.Sprintf("only %.0f%% of %s RAM, 25%% is a common starting point", pct, humanize.IBytes(uint64(ram))))
+		} else if pct > 40 {
+			add(sevWarning, "shared_buffers", fmtMemSetting(sb), fmtMemSetting(roundMem(ram/4)),
+				fmt.Sprintf("%.0f%% of %s RAM leaves too little for the OS page cache and backends", pct, humanize.IBytes(uint64(ram))))
+		}
+	}
+
+	// effective_cache_size should reflect the RAM available for caching
+	if ecs := int64(getSettingInt(result, "effective_cache_size")) * blockSize; ram > 0 && ecs > 0 && ecs < ram/2 {
+		add(sevInfo, "effective_cache_size", fmtMemSetting(ecs), fmtMemSetting(roundMem(ram*3/4)),
+			"less than half of RAM, the planner may underestimate the benefit of index scans")
+	}
+
+	// work_mem can be used many times over by each connection
+	wm := int64(getSettingInt(result, "work_mem")) * 1024
+	maxConn := int64(getSettingInt(result, "max_connections"))
+	if ram > 0 && wm > 0 && maxConn > 0 {
+		total := wm * maxConn
+		suggest := roundMem((ram - sb) / (4 * maxConn))
+		rationale := fmt.Sprintf("work_mem x max_connections = %s, with %s RAM; each sort or hash in each query can use this much",
+			humanize.IBytes(uint64(total)), humanize.IBytes(uint64(ram)))
+		if total > ram {
+			add(sevCritical, "work_mem", fmtMemSetting(wm), fmtMemSetting(suggest), rationale)
+		} else if total > ram/2 {
+			add(sevWarning, "work_mem", fmtMemSetting(wm), fmtMemSetting(suggest), rationale)
+		}
+	}
+
+	// checkpoints should happen because of checkpoint_timeout, not because
+	// max_wal_size was reached
+	bg := &result.BGWriter
+	nckpt := bg.CheckpointsTimed + bg.CheckpointsRequested
+	reqPct := 100 * safeDiv(bg.CheckpointsRequested, nckpt)
+	ckptTimeout := int64(getSettingInt(result, "checkpoint_timeout"))
+	if nckpt >= 10 && reqPct < 10 && ckptTimeout > 0 && ckptTimeout < 900 {
+		add(sevInfo, "checkpoint_timeout", fmt.Sprintf("%ds", ckptTimeout), "15min",
+			fmt.Sprintf("%.0f%% of checkpoints are timed, less frequent checkpoints reduce full-page writes", 100-reqPct))
+	}
+
+	// max_wal_size should hold the WAL generated in one checkpoint cycle
+	if mws := getMaxWalSizeBytes(result); mws > 0 && ckptTimeout > 0 {
+		cycles := 1 + getSettingFloat(result, "checkpoint_completion_target")
+		if version < 110000 {
+			cycles++ // also retains the WAL of the checkpoint before the last
+		}
+		if rate := getWALRate(result); rate > 0 {
+			need := int64(rate * float64(ckptTimeout) * cycles)
+			if need > mws {
+				sev := sevInfo
+				if nckpt >= 10 && reqPct >= 10 {
+					sev = sevWarning
+				}
+				add(sev, "max_wal_size", fmtMemSetting(mws), fmtMemSetting(roundMem(need)),
+					fmt.Sprintf("WAL is generated at %s/s, which fills max_wal_size before checkpoint_timeout elapses",
+						humanize.IBytes(uint64(rate))))
+			}
+		} else if nckpt >= 10 && reqPct >= 10 {
+			add(sevWarning, "max_wal_size", fmtMemSetting(mws), fmtMemSetting(roundMem(2*mws)),
+				fmt.Sprintf("%.0f%% of checkpoints were requested, rather than timed", reqPct))
+		}
+	}
+
+	// random_page_cost is too high for SSDs
+	if rpc := getSettingFloat(result, "random_page_cost"); rpc >= 2 {
+		if result.RDS != nil {
+			add(sevWarning, "random_page_cost", getSetting(result, "random_page_cost"), "1.1",
+				"RDS storage is SSD-based, random reads are not much costlier than sequential ones")
+		} else {
+			add(sevInfo, "random_page_cost", getSetting(result, "random_page_cost"), "1.1",
+				"if the storage is SSD-based, random reads are not much costlier than sequential ones")
+		}
+	}
+
+	// wal_compression reduces the size of full-page writes
+	if version >= 90500 && getSetting(result, "wal_compression") == "off" {
+		add(sevInfo, "wal_compression", "off", "on",
+			"compresses full-page images in WAL, reducing WAL volume at a small CPU cost")
+	}
+
+	// default_statistics_target
+	if dst := getSettingInt(result, "default_statistics_target"); dst > 0 && dst < 100 {
+		add(sevWarning, "default_statistics_target", fmt.Sprint(dst), "100",
+			"too few statistics samples can lead to poor query plans")
+	} else if dst > 1000 {
+		add(sevInfo, "default_statistics_target", fmt.Sprint(dst), "100",
+			"large targets slow down ANALYZE and planning, prefer setting it per column")
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return severityRank(out[i].severity) > severityRank(out[j].severity)
+	})
+	return
+}
+
+func reportConfigAdvice(fd io.Writer, result *pgmetrics.Model) {
+	findings := getConfigAdvice(result)
+	if len(findings) == 0 {
+		return
+	}
+	fmt.Fprint(fd, `
+Configuration Advisor:
+`)
+	var tw tableWriter
+	tw.add("Severity", "Setting", "Current", "Suggested", "Rationale")
+	for _, f := range findings {
+		tw.add(f.severity, f.setting, f.current, f.suggested, f.rationale)
+	}
+	tw.write(fd, "    ")
+}
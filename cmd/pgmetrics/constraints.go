@@ -0,0 +1,267 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// unquoteIdent removes the double quotes around an identifier, if present.
+func unquoteIdent(s string) string {
+	if n := len(s); n >= 2 && s[0] == '"' && s[n-1] == '"' {
+		return strings.Replace(s[1:n-1], `""`, `"`, -1)
+	}
+	return s
+}
+
+// indexCoversColumns checks if the leading columns of the index are exactly
+// the given columns, in any order.
+func indexCoversColumns(idx *pgmetrics.Index, cols []string) bool {
+	icols := indexColumns(idx)
+	if len(cols) == 0 || len(icols) < len(cols) {
+		return false
+	}
+	want := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		want[c] = true
+	}
+	for _, ic := range icols[:len(cols)] {
+		if !want[unquoteIdent(ic)] {
+			return false
+		}
+	}
+	return true
+}
+
+// unindexedFK is a foreign key whose columns are not indexed. For a foreign
+// key on a partitioned table, the partitions lacking the index are counted.
+type unindexedFK struct {
+	fk          *pgmetrics.Constraint
+	partitioned bool
+	unindexed   int // number of partitions without an index
+	partitions  int // total number of partitions
+}
+
+// isFKIndexed checks if the columns of the foreign key are covered by the
+// leading columns of any valid, non-partial index on its table.
+func isFKIndexed(result *pgmetrics.Model, fk *pgmetrics.Constraint) bool {
+	for j := range result.Indexes {
+		idx := &result.Indexes[j]
+		if idx.DBName != fk.DBName || idx.TableOID != fk.TableOID {
+			continue
+		}
+		if !idx.IsValid || isPartialIndex(idx) {
+			continue
+		}
+		if indexCoversColumns(idx, fk.Columns) {
+			return true
+		}
+	}
+	return false
+}
+
+// getUnindexedFKs returns the foreign keys whose columns are not covered by
+// the leading columns of any valid, non-partial index on the table. A foreign
+// key on a partitioned table is cloned onto each partition, and is reported
+// only once, on the topmost table, if any of the partitions is not indexed.
+// Indexes on partitioned tables themselves are not collected, so only the
+// partitions are checked.
+func getUnindexedFKs(result *pgmetrics.Model) (out []unindexedFK) {
+	type conKey struct {
+		db  string
+		oid int
+	}
+	type tableKey struct {
+		db  string
+		oid int
+	}
+	partitioned := make(map[tableKey]bool)
+	for _, t := range result.Tables {
+		if t.RelKind == "p" {
+			partitioned[tableKey{t.DBName, t.OID}] = true
+		}
+	}
+	byOID := make(map[conKey]*pgmetrics.Constraint)
+	hasClones := make(map[conKey]bool)
+	for i := range result.Constraints {
+		con := &result.Constraints[i]
+		if con.Type == "f" {
+			byOID[conKey{con.DBName, con.OID}] = con
+			if con.ParentOID != 0 {
+				hasClones[conKey{con.DBName, con.ParentOID}] = true
+			}
+		}
+	}
+	// root returns the topmost collected constraint that fk was cloned from
+	root := func(fk *pgmetrics.Constraint) *pgmetrics.Constraint {
+		for fk.ParentOID != 0 {
+			p, ok := byOID[conKey{fk.DBName, fk.ParentOID}]
+			if !ok {
+				break
+			}
+			fk = p
+		}
+		return fk
+	}
+
+	byRoot := make(map[*pgmetrics.Constraint]*unindexedFK)
+	for i := range result.Constraints {
+		fk := &result.Constraints[i]
+		if fk.Type != "f" {
+			continue
+		}
+		if partitioned[tableKey{fk.DBName, fk.TableOID}] &&
+			!hasClones[conKey{fk.DBName, fk.OID}] {
+			continue // partitioned table without partitions
+		}
+		r := root(fk)
+		u, ok := byRoot[r]
+		if !ok {
+			u = &unindexedFK{fk: r, partitioned: hasClones[conKey{r.DBName, r.OID}]}
+			byRoot[r] = u
+		}
+		if u.partitioned && fk == r {
+			continue // the partitions are checked instead
+		}
+		// intermediate partitioned tables have no collected indexes either
+		if hasClones[conKey{fk.DBName, fk.OID}] {
+			continue
+		}
+		u.partitions++
+		if !isFKIndexed(result, fk) {
+			u.unindexed++
+		}
+	}
+	for i := range result.Constraints {
+		if u, ok := byRoot[&result.Constraints[i]]; ok && u.unindexed > 0 {
+			out = append(out, *u)
+		}
+	}
+	return
+}
+
+// getTablesWithoutPK returns the tables that have neither a primary key nor
+// a usable replica identity. UPDATEs and DELETEs on such tables fail if they
+// are part of a publication.
+func getTablesWithoutPK(result *pgmetrics.Model) (out []*pgmetrics.Table) {
+	type tableKey struct {
+		db  string
+		oid int
+	}
+	hasPK := make(map[tableKey]bool)
+	for _, con := range result.Constraints {
+		if con.Type == "p" {
+			hasPK[tableKey{con.DBName, con.TableOID}] = true
+		}
+	}
+	for i := range result.Tables {
+		t := &result.Tables[i]
+		if t.RelKind != "r" || hasPK[tableKey{t.DBName, t.OID}] {
+			continue
+		}
+		if t.ReplIdent == "f" || t.ReplIdent == "i" {
+			continue
+		}
+		out = append(out, t)
+	}
+	return
+}
+
+func fmtReplIdent(ri string) string {
+	switch ri {
+	case "d":
+		return "default"
+	case "n":
+		return "nothing"
+	case "f":
+		return "full"
+	case "i":
+		return "index"
+	}
+	return ri
+}
+
+func quoteColumns(cols []string) string {
+	q := make([]string, len(cols))
+	for i, c := range cols {
+		q[i] = quoteIdent(c)
+	}
+	return strings.Join(q, ", ")
+}
+
+func reportConstraints(fd io.Writer, result *pgmetrics.Model) {
+	// constraints are available only from schema 1.11
+	if !schemaAtLeast(result, 1, 11) || len(result.Tables) == 0 {
+		return
+	}
+
+	if fks := getUnindexedFKs(result); len(fks) > 0 {
+		fmt.Fprint(fd, `
+Foreign Keys Without Indexes:
+`)
+		var tw tableWriter
+		tw.add("Table", "Constraint", "Columns", "References", "Partitions Unindexed")
+		for _, u := range fks {
+			fk := u.fk
+			var parts string
+			if u.partitioned {
+				parts = fmt.Sprintf("%d of %d", u.unindexed, u.partitions)
+			}
+			tw.add(fk.DBName+"."+fk.SchemaName+"."+fk.TableName, fk.Name,
+				strings.Join(fk.Columns, ", "),
+				fk.RefSchemaName+"."+fk.RefTableName, parts)
+		}
+		tw.write(fd, "    ")
+		fmt.Fprint(fd, `    Suggested Statements:
+`)
+		lastDB := ""
+		for _, u := range fks {
+			fk := u.fk
+			if fk.DBName != lastDB {
+				fmt.Fprintf(fd, "      -- in database %s\n", fk.DBName)
+				lastDB = fk.DBName
+			}
+			// indexes on partitioned tables cannot be created concurrently,
+			// but are created on each partition
+			conc := "CONCURRENTLY "
+			if u.partitioned {
+				conc = ""
+			}
+			fmt.Fprintf(fd, "      CREATE INDEX %sON %s.%s (%s);\n", conc,
+				quoteIdent(fk.SchemaName), quoteIdent(fk.TableName),
+				quoteColumns(fk.Columns))
+		}
+	}
+
+	if ts := getTablesWithoutPK(result); len(ts) > 0 {
+		fmt.Fprint(fd, `
+Tables Without Primary Key or Replica Identity:
+`)
+		var tw tableWriter
+		tw.add("Table", "Replica Identity", "Published In")
+		for _, t := range ts {
+			tw.add(t.DBName+"."+t.SchemaName+"."+t.Name,
+				fmtReplIdent(t.ReplIdent),
+				strings.Join(getTablePublications(result, t), ", "))
+		}
+		tw.write(fd, "    ")
+	}
+}
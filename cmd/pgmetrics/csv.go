@@ -83,6 +83,23 @@ func model2csv(m *pgmetrics.Model, w *csv.Writer) (err error) {
 		struct2csv(head, vp, w)
 	}
 
+	// progress of other commands
+	for i, p := range m.CreateIndexProgress {
+		struct2csv(fmt.Sprintf("pgmetrics.progress.create_index.%d.", i), p, w)
+	}
+	for i, p := range m.ClusterProgress {
+		struct2csv(fmt.Sprintf("pgmetrics.progress.cluster.%d.", i), p, w)
+	}
+	for i, p := range m.AnalyzeProgress {
+		struct2csv(fmt.Sprintf("pgmetrics.progress.analyze.%d.", i), p, w)
+	}
+	for i, p := range m.BasebackupProgress {
+		struct2csv(fmt.Sprintf("pgmetrics.progress.basebackup.%d.", i), p, w)
+	}
+	for i, p := range m.CopyProgress {
+		struct2csv(fmt.Sprintf("pgmetrics.progress.copy.%d.", i), p, w)
+	}
+
 	// databases
 	rec2csv("pgmetrics.databases.count", strconv.Itoa(len(m.Databases)), w)
 	for _, db := range m.Databases {
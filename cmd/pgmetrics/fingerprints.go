@@ -0,0 +1,172 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Show only these many fingerprints in the report.
+const fingerprintTopN = 10
+
+// setFingerprints fills in the fingerprints of statements, plans and slow
+// queries in reports from older pgmetrics versions. The collector sets these
+// from the untruncated queries, which are not available here.
+func setFingerprints(result *pgmetrics.Model) {
+	for i := range result.Statements {
+		if s := &result.Statements[i]; len(s.Fingerprint) == 0 {
+			s.Fingerprint = pgmetrics.Fingerprint(s.Query)
+		}
+	}
+	if si := result.StatementsInterval; si != nil {
+		for i := range si.Statements {
+			if s := &si.Statements[i]; len(s.Fingerprint) == 0 {
+				s.Fingerprint = pgmetrics.Fingerprint(s.Query)
+			}
+		}
+	}
+	for i := range result.Plans {
+		if p := &result.Plans[i]; len(p.Fingerprint) == 0 && len(p.Query) > 0 {
+			p.Fingerprint = pgmetrics.Fingerprint(p.Query)
+		}
+	}
+	for i := range result.SlowQueries {
+		if q := &result.SlowQueries[i]; len(q.Fingerprint) == 0 {
+			q.Fingerprint = pgmetrics.Fingerprint(q.Query)
+		}
+	}
+}
+
+// fpRollup is the aggregate of everything seen with the same fingerprint.
+type fpRollup struct {
+	fingerprint string
+	query       string
+	stmts       int
+	users       map[string]bool
+	dbs         map[string]bool
+	calls       int64
+	totalTime   float64
+	slowCount   int
+	slowMax     float64
+	plans       int
+}
+
+// rollupFingerprints aggregates statements, slow queries and plans by their
+// fingerprints, most time consuming first.
+func rollupFingerprints(result *pgmetrics.Model) (out []*fpRollup) {
+	m := make(map[string]*fpRollup)
+	get := func(fp, query string) *fpRollup {
+		r, ok := m[fp]
+		if !ok {
+			r = &fpRollup{
+				fingerprint: fp,
+				query:       query,
+				users:       make(map[string]bool),
+				dbs:         make(map[string]bool),
+			}
+			m[fp] = r
+			out = append(out, r)
+		}
+		return r
+	}
+	for _, s := range result.Statements {
+		r := get(s.Fingerprint, s.Query)
+		r.stmts++
+		r.users[s.UserName] = true
+		r.dbs[s.DBName] = true
+		r.calls += s.Calls
+		r.totalTime += s.TotalTime
+	}
+	for _, q := range result.SlowQueries {
+		r := get(q.Fingerprint, q.Query)
+		r.slowCount++
+		if q.Duration > r.slowMax {
+			r.slowMax = q.Duration
+		}
+		if len(q.UserName) > 0 {
+			r.users[q.UserName] = true
+		}
+		if len(q.Database) > 0 {
+			r.dbs[q.Database] = true
+		}
+	}
+	for _, p := range result.Plans {
+		if len(p.Fingerprint) == 0 {
+			continue
+		}
+		r := get(p.Fingerprint, p.Query)
+		r.plans++
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].totalTime != out[j].totalTime {
+			return out[i].totalTime > out[j].totalTime
+		}
+		if out[i].slowCount != out[j].slowCount {
+			return out[i].slowCount > out[j].slowCount
+		}
+		return out[i].slowMax > out[j].slowMax
+	})
+	return
+}
+
+func reportFingerprints(fd io.Writer, result *pgmetrics.Model) {
+	if len(result.Statements) == 0 && len(result.SlowQueries) == 0 {
+		return
+	}
+	rollups := rollupFingerprints(result)
+
+	// only interesting if some fingerprint covers more than one query, or if
+	// there are slow queries to match with statements
+	var merged int
+	for _, r := range rollups {
+		if r.stmts > 1 {
+			merged++
+		}
+	}
+	if merged == 0 && len(result.SlowQueries) == 0 {
+		return
+	}
+
+	fmt.Fprintf(fd, `
+Query Fingerprints:
+    Fingerprints:        %d
+    Merged:              %d (with more than one pg_stat_statements entry)
+    Slow Queries Logged: %d
+`,
+		len(rollups), merged, len(result.SlowQueries))
+
+	var tw tableWriter
+	tw.add("Fingerprint", "Entries", "Users", "DBs", "Calls", "Total Time",
+		"Slow", "Max Slow", "Plans", "Query")
+	for i, r := range rollups {
+		if i == fingerprintTopN {
+			break
+		}
+		slowMax := ""
+		if r.slowCount > 0 {
+			slowMax = prepmsec(r.slowMax)
+		}
+		tw.add(r.fingerprint, r.stmts, len(r.users), len(r.dbs), r.calls,
+			prepmsec(r.totalTime), r.slowCount, slowMax, r.plans,
+			prepQ(pgmetrics.NormalizeQuery(r.query)))
+	}
+	tw.write(fd, "    ")
+}
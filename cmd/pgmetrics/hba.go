@@ -0,0 +1,239 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// hbaFinding is a problem found with a single pg_hba.conf rule.
+type hbaFinding struct {
+	rule     *pgmetrics.HBARule
+	severity string
+	problem  string
+}
+
+// fmtHBAAddress formats the address and netmask of the rule in CIDR notation
+// where possible.
+func fmtHBAAddress(r *pgmetrics.HBARule) string {
+	if len(r.NetMask) == 0 {
+		return r.Address
+	}
+	if ip := net.ParseIP(r.NetMask); ip != nil {
+		mask := net.IPMask(ip)
+		if ip4 := ip.To4(); ip4 != nil && strings.Contains(r.Address, ".") {
+			mask = net.IPMask(ip4)
+		}
+		if ones, bits := mask.Size(); bits != 0 {
+			return fmt.Sprintf("%s/%d", r.Address, ones)
+		}
+	}
+	return r.Address + "/" + r.NetMask
+}
+
+// isNetworkRule returns true if the rule applies to TCP/IP connections.
+func isNetworkRule(r *pgmetrics.HBARule) bool {
+	return r.Type != "local"
+}
+
+// isOpenToAll returns true if the rule matches connections from any address.
+func isOpenToAll(r *pgmetrics.HBARule) bool {
+	if !isNetworkRule(r) {
+		return false
+	}
+	return r.Address == "all" ||
+		(r.Address == "0.0.0.0" && r.NetMask == "0.0.0.0") ||
+		(r.Address == "::" && r.NetMask == "::")
+}
+
+func getHBAFindings(result *pgmetrics.Model) (out []hbaFinding) {
+	add := func(r *pgmetrics.HBARule, sev, problem string) {
+		out = append(out, hbaFinding{rule: r, severity: sev, problem: problem})
+	}
+	version := getVersion(result)
+	for i := range result.HBARules {
+		r := &result.HBARules[i]
+		if len(r.Error) > 0 {
+			add(r, sevCritical, "could not be parsed: "+r.Error)
+			continue
+		}
+		switch r.AuthMethod {
+		case "trust":
+			if isNetworkRule(r) {
+				add(r, sevCritical, "trust allows network connections without a password")
+			} else {
+				add(r, sevWarning, "trust allows any local OS user to connect as any role")
+			}
+		case "password":
+			if r.Type == "hostssl" {
+				add(r, sevWarning, "password sends the password in clear text")
+			} else if isNetworkRule(r) {
+				add(r, sevCritical, "password sends the password in clear text, possibly without SSL")
+			}
+		case "md5":
+			if version == 0 || version >= 100000 {
+				add(r, sevInfo, "md5 is in use, scram-sha-256 is available")
+			}
+		case "ident":
+			if isNetworkRule(r) {
+				add(r, sevWarning, "ident over TCP/IP trusts the identd on the client host")
+			}
+		}
+		if isOpenToAll(r) && arrayHas(r.Databases, "all") && arrayHas(r.Users, "all") &&
+			r.AuthMethod != "reject" {
+			add(r, sevWarning, "all databases and all users, from any address")
+		}
+	}
+	return
+}
+
+// isMemberOf checks if the role is directly or indirectly a member of group.
+func isMemberOf(result *pgmetrics.Model, role *pgmetrics.Role, group string) bool {
+	seen := make(map[string]bool)
+	var check func(r *pgmetrics.Role) bool
+	check = func(r *pgmetrics.Role) bool {
+		for _, m := range r.MemberOf {
+			if m == group {
+				return true
+			}
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			for i := range result.Roles {
+				if result.Roles[i].Name == m && check(&result.Roles[i]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return role.Name == group || check(role)
+}
+
+// hbaMatchesRole checks if the user field of the rule matches the role.
+func hbaMatchesRole(result *pgmetrics.Model, r *pgmetrics.HBARule, role *pgmetrics.Role) bool {
+	for _, u := range r.Users {
+		switch {
+		case u == "all" || u == role.Name:
+			return true
+		case strings.HasPrefix(u, "+"):
+			if isMemberOf(result, role, u[1:]) {
+				return true
+			}
+		case strings.HasPrefix(u, "/"):
+			if rx, err := regexp.Compile(u[1:]); err == nil && rx.MatchString(role.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hbaCovers checks if every connection that matches the rule b (for the same
+// role) would also match the rule a, that is, if a shadows b when a comes
+// before b in pg_hba.conf.
+func hbaCovers(a, b *pgmetrics.HBARule) bool {
+	if a.Type != b.Type && a.Type != "host" {
+		return false
+	}
+	// "all" does not match replication connections
+	allDBs := arrayHas(a.Databases, "all") && !arrayHas(b.Databases, "replication")
+	if !allDBs && strings.Join(a.Databases, ",") != strings.Join(b.Databases, ",") {
+		return false
+	}
+	return isOpenToAll(a) || (a.Address == b.Address && a.NetMask == b.NetMask)
+}
+
+// getSuperuserNetworkRules returns the rules through which the superuser can
+// log in over the network. Only the first matching rule applies to a
+// connection, so rules shadowed by earlier matching ones, including reject
+// rules, are not returned.
+func getSuperuserNetworkRules(result *pgmetrics.Model, role *pgmetrics.Role) (out []*pgmetrics.HBARule) {
+	var matched []*pgmetrics.HBARule
+	for j := range result.HBARules {
+		r := &result.HBARules[j]
+		if len(r.Error) > 0 || !isNetworkRule(r) || !hbaMatchesRole(result, r, role) {
+			continue
+		}
+		shadowed := false
+		for _, m := range matched {
+			if hbaCovers(m, r) {
+				shadowed = true
+				break
+			}
+		}
+		if shadowed {
+			continue
+		}
+		matched = append(matched, r)
+		if r.AuthMethod != "reject" {
+			out = append(out, r)
+		}
+	}
+	return
+}
+
+func reportHBA(fd io.Writer, result *pgmetrics.Model) {
+	if len(result.HBARules) == 0 {
+		return
+	}
+
+	findings := getHBAFindings(result)
+	fmt.Fprintf(fd, `
+Client Authentication (pg_hba.conf):
+    Rules:               %d
+    Problems Found:      %d
+`,
+		len(result.HBARules), len(findings))
+	if len(findings) > 0 {
+		var tw tableWriter
+		tw.add("Line", "Type", "Database", "User", "Address", "Method", "Severity", "Problem")
+		for _, f := range findings {
+			r := f.rule
+			tw.add(r.LineNumber, r.Type, strings.Join(r.Databases, ","),
+				strings.Join(r.Users, ","), fmtHBAAddress(r), r.AuthMethod,
+				f.severity, f.problem)
+		}
+		tw.write(fd, "    ")
+	}
+
+	// superusers that can log in over the network
+	var tw tableWriter
+	tw.add("Superuser", "Line", "Type", "Database", "Address", "Method")
+	for i := range result.Roles {
+		role := &result.Roles[i]
+		if !role.Rolsuper || !role.Rolcanlogin {
+			continue
+		}
+		for _, r := range getSuperuserNetworkRules(result, role) {
+			tw.add(role.Name, r.LineNumber, r.Type,
+				strings.Join(r.Databases, ","), fmtHBAAddress(r), r.AuthMethod)
+		}
+	}
+	if len(tw.data) > 1 {
+		fmt.Fprint(fd, `    Superusers With Network Access:
+`)
+		tw.write(fd, "      ")
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// xminHolder is something that holds back the xmin horizon, preventing vacuum
+// from removing dead tuples newer than its xmin.
+type xminHolder struct {
+	kind   string // "backend", "replication slot", "standby" or "prepared xact"
+	name   string // identifies the holder within its kind
+	xmin   int
+	since  int64 // when the holder started holding, 0 if unknown
+	age    int   // in transactions, -1 if unknown
+	detail string
+}
+
+// xidAge returns the number of transactions between xid and next, taking
+// wraparound into account. If next is not known, it returns -1.
+func xidAge(next, xid int) int {
+	if next == 0 || xid == 0 {
+		return -1
+	}
+	age := int(int32(uint32(next) - uint32(xid)))
+	if age < 0 {
+		// next is from the last checkpoint, xid can be newer than that
+		age = 0
+	}
+	return age
+}
+
+// xidBefore returns true if xid a is logically older than xid b.
+func xidBefore(a, b int) bool {
+	return int32(uint32(a)-uint32(b)) < 0
+}
+
+// getXminHolders returns the list of everything that is holding back the
+// xmin horizon, oldest first.
+func getXminHolders(result *pgmetrics.Model) (out []xminHolder) {
+	add := func(h xminHolder) {
+		if h.xmin != 0 {
+			h.age = xidAge(result.NextXid, h.xmin)
+			out = append(out, h)
+		}
+	}
+
+	// backends with an open snapshot or an assigned xid
+	for _, be := range result.Backends {
+		xmin := be.BackendXmin
+		if xmin == 0 || (be.BackendXid != 0 && xidBefore(be.BackendXid, xmin)) {
+			xmin = be.BackendXid
+		}
+		add(xminHolder{
+			kind:   "backend",
+			name:   fmt.Sprintf("PID %d", be.PID),
+			xmin:   xmin,
+			since:  be.XactStart,
+			detail: getBEClient(&be) + ", " + be.State,
+		})
+	}
+
+	// replication slots, both for regular and catalog tuples
+	for _, rs := range result.ReplicationSlots {
+		detail := rs.SlotType
+		if !rs.Active {
+			detail += ", inactive"
+		}
+		add(xminHolder{
+			kind:   "replication slot",
+			name:   rs.SlotName,
+			xmin:   rs.Xmin,
+			detail: detail,
+		})
+		add(xminHolder{
+			kind:   "replication slot",
+			name:   rs.SlotName,
+			xmin:   rs.CatalogXmin,
+			detail: detail + ", catalog xmin",
+		})
+	}
+
+	// standbys with hot_standby_feedback = on
+	for _, r := range result.ReplicationOutgoing {
+		name := r.ApplicationName
+		if len(name) == 0 {
+			name = r.ClientAddr
+		}
+		add(xminHolder{
+			kind:   "standby",
+			name:   name,
+			xmin:   r.BackendXmin,
+			since:  r.BackendStart,
+			detail: r.RoleName + "@" + r.ClientAddr + ", hot_standby_feedback",
+		})
+	}
+
+	// prepared transactions
+	for _, p := range result.PreparedXacts {
+		add(xminHolder{
+			kind:   "prepared xact",
+			name:   p.GID,
+			xmin:   p.Transaction,
+			since:  p.Prepared,
+			detail: p.Owner + "/" + p.DBName,
+		})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return xidBefore(out[i].xmin, out[j].xmin)
+	})
+	return
+}
+
+func fmtXidAge(age int) string {
+	if age < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", age)
+}
+
+const maxXminHolders = 10
+
+func reportXminHorizon(fd io.Writer, result *pgmetrics.Model) {
+	holders := getXminHolders(result)
+	if len(holders) == 0 {
+		return
+	}
+
+	oldest := holders[0]
+	var age string
+	if oldest.age >= 0 {
+		age = fmt.Sprintf(", %d xacts old", oldest.age)
+	}
+	fmt.Fprintf(fd, `
+Xmin Horizon:
+    Oldest Xmin:         %d%s
+    Held By:             %s %s (%s)`,
+		oldest.xmin, age,
+		oldest.kind, oldest.name, oldest.detail,
+	)
+	if oldest.since != 0 {
+		fmt.Fprintf(fd, `
+    Holding Since:       %s`, fmtTimeAndSince(oldest.since))
+	}
+	fmt.Fprintf(fd, `
+    Prepared Xacts:      %d
+`, len(result.PreparedXacts))
+
+	var tw tableWriter
+	tw.add("Held By", "Name", "Xmin", "Age", "Since", "Detail")
+	for i, h := range holders {
+		if i == maxXminHolders {
+			break
+		}
+		tw.add(h.kind, h.name, h.xmin, fmtXidAge(h.age), fmtTime(h.since), h.detail)
+	}
+	tw.write(fd, "    ")
+}
@@ -0,0 +1,314 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Indexes are reported as unused only if the statistics have been gathered
+// for at least this long.
+const minUnusedStatsAge = 7 * 24 * time.Hour
+
+// indexAdvice is a single problem found with an index.
+type indexAdvice struct {
+	idx     *pgmetrics.Index
+	problem string
+}
+
+// indexDefKey returns the definition of the index with its name removed, so
+// that two indexes with the same key have identical definitions.
+func indexDefKey(idx *pgmetrics.Index) string {
+	def := idx.Definition
+	if pos := strings.Index(def, " ON "); pos > 0 {
+		if ipos := strings.Index(def, "INDEX "); ipos >= 0 && ipos < pos {
+			def = def[:ipos+6] + def[pos+1:]
+		}
+	}
+	return def
+}
+
+// indexColumns returns the key columns of the index. For models older than
+// schema 1.11, these are parsed out of the index definition.
+func indexColumns(idx *pgmetrics.Index) []string {
+	if len(idx.Columns) > 0 {
+		return idx.Columns
+	}
+	// CREATE INDEX name ON table USING method (col1, col2) INCLUDE (..) WHERE ..
+	def := idx.Definition
+	pos := strings.Index(def, " USING ")
+	if pos < 0 {
+		return nil
+	}
+	def = def[pos:]
+	if pos = strings.IndexByte(def, '('); pos < 0 {
+		return nil
+	}
+	var cols []string
+	depth, start, quoted := 0, pos+1, false
+	for i := pos; i < len(def); i++ {
+		switch ch := def[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth == 0 {
+				return append(cols, strings.TrimSpace(def[start:i]))
+			}
+		case ch == ',' && depth == 1:
+			cols = append(cols, strings.TrimSpace(def[start:i]))
+			start = i + 1
+		}
+	}
+	return nil
+}
+
+func isPartialIndex(idx *pgmetrics.Index) bool {
+	return strings.Contains(idx.Definition, " WHERE ")
+}
+
+func isUniqueIndex(idx *pgmetrics.Index) bool {
+	return idx.IsUnique || idx.IsPrimary ||
+		strings.HasPrefix(idx.Definition, "CREATE UNIQUE INDEX")
+}
+
+// isPrefixOf checks if the columns a are a proper left-prefix of b.
+func isPrefixOf(a, b []string) bool {
+	if len(a) == 0 || len(a) >= len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// getConstraintIndexes returns the indexes that back primary key or unique
+// constraints. Such an index always has the same name as its constraint, and
+// cannot be dropped without dropping the constraint.
+func getConstraintIndexes(result *pgmetrics.Model) map[*pgmetrics.Index]bool {
+	type key struct {
+		db       string
+		tableOID int
+		name     string
+	}
+	names := make(map[key]bool)
+	for _, c := range result.Constraints {
+		if c.Type == "p" || c.Type == "u" {
+			names[key{c.DBName, c.TableOID, c.Name}] = true
+		}
+	}
+	out := make(map[*pgmetrics.Index]bool)
+	for i := range result.Indexes {
+		idx := &result.Indexes[i]
+		if idx.IsPrimary || names[key{idx.DBName, idx.TableOID, idx.Name}] {
+			out[idx] = true
+		}
+	}
+	return out
+}
+
+// keepIndexFirst orders indexes with the same definition so that the one that
+// should be retained comes first.
+func keepIndexFirst(a, b *pgmetrics.Index, constraint map[*pgmetrics.Index]bool) bool {
+	if a.IsValid != b.IsValid {
+		return a.IsValid
+	}
+	if a.IsPrimary != b.IsPrimary {
+		return a.IsPrimary
+	}
+	if constraint[a] != constraint[b] {
+		return constraint[a]
+	}
+	if a.IdxScan != b.IdxScan {
+		return a.IdxScan > b.IdxScan
+	}
+	return a.Name < b.Name
+}
+
+// getIndexAdvice examines the indexes in the model and returns the ones that
+// are invalid, duplicated, redundant or unused. Each index is reported only
+// once, for its most serious problem. Indexes backing constraints are not
+// reported, since they cannot be dropped with DROP INDEX.
+func getIndexAdvice(result *pgmetrics.Model) (out []indexAdvice) {
+	constraint := getConstraintIndexes(result)
+	flagged := make(map[*pgmetrics.Index]bool)
+	flag := func(idx *pgmetrics.Index, problem string) {
+		if !flagged[idx] && !constraint[idx] {
+			flagged[idx] = true
+			out = append(out, indexAdvice{idx: idx, problem: problem})
+		}
+	}
+
+	// group indexes by table
+	type tableKey struct {
+		db  string
+		oid int
+	}
+	var keys []tableKey
+	byTable := make(map[tableKey][]*pgmetrics.Index)
+	for i := range result.Indexes {
+		idx := &result.Indexes[i]
+		k := tableKey{idx.DBName, idx.TableOID}
+		if _, ok := byTable[k]; !ok {
+			keys = append(keys, k)
+		}
+		byTable[k] = append(byTable[k], idx)
+	}
+
+	// never-scanned non-unique indexes, if stats have been around long enough
+	unused := make(map[*pgmetrics.Index]bool)
+	for i := range result.Indexes {
+		idx := &result.Indexes[i]
+		if idx.IdxScan == 0 && !isUniqueIndex(idx) &&
+			statsAge(result, idx.DBName) >= minUnusedStatsAge {
+			unused[idx] = true
+		}
+	}
+
+	// invalid indexes, flags are available only from schema 1.11
+	if schemaAtLeast(result, 1, 11) {
+		for i := range result.Indexes {
+			if idx := &result.Indexes[i]; !idx.IsValid {
+				flag(idx, "invalid, possibly a failed CREATE INDEX CONCURRENTLY")
+			}
+		}
+	}
+
+	for _, k := range keys {
+		idxs := byTable[k]
+
+		// exact duplicates
+		byDef := make(map[string][]*pgmetrics.Index)
+		for _, idx := range idxs {
+			if len(idx.Definition) > 0 {
+				dk := indexDefKey(idx)
+				byDef[dk] = append(byDef[dk], idx)
+			}
+		}
+		for _, idx := range idxs {
+			dups := byDef[indexDefKey(idx)]
+			if len(dups) < 2 || dups[0] != idx {
+				continue
+			}
+			sort.Slice(dups, func(i, j int) bool {
+				return keepIndexFirst(dups[i], dups[j], constraint)
+			})
+			for _, d := range dups[1:] {
+				flag(d, "duplicate of "+dups[0].Name)
+			}
+		}
+
+		// left-prefix redundant btree indexes, not counting indexes that are
+		// themselves going to be dropped
+		for _, a := range idxs {
+			if a.AMName != "btree" || isUniqueIndex(a) || isPartialIndex(a) {
+				continue
+			}
+			acols := indexColumns(a)
+			for _, b := range idxs {
+				if a == b || b.AMName != "btree" || isPartialIndex(b) || flagged[b] ||
+					unused[b] {
+					continue
+				}
+				if isPrefixOf(acols, indexColumns(b)) {
+					flag(a, "redundant, left-prefix of "+b.Name)
+					break
+				}
+			}
+		}
+	}
+
+	for i := range result.Indexes {
+		if idx := &result.Indexes[i]; unused[idx] {
+			flag(idx, "never scanned")
+		}
+	}
+	return
+}
+
+// statsAge returns how long the statistics of the given database have been
+// accumulating. If they have never been reset, a very large value is returned.
+func statsAge(result *pgmetrics.Model, db string) time.Duration {
+	for _, d := range result.Databases {
+		if d.Name == db && d.StatsReset != 0 {
+			return time.Duration(result.Metadata.At-d.StatsReset) * time.Second
+		}
+	}
+	return time.Duration(1<<63 - 1)
+}
+
+func reportIndexAdvice(fd io.Writer, result *pgmetrics.Model) {
+	advice := getIndexAdvice(result)
+	if len(advice) == 0 {
+		return
+	}
+	sort.SliceStable(advice, func(i, j int) bool {
+		return advice[i].idx.DBName < advice[j].idx.DBName
+	})
+
+	var reclaim int64
+	for _, a := range advice {
+		if a.idx.Size > 0 {
+			reclaim += a.idx.Size
+		}
+	}
+	fmt.Fprintf(fd, `
+Index Advisor:
+    Indexes Flagged:     %d
+    Reclaimable Size:    %s
+`,
+		len(advice),
+		humanize.IBytes(uint64(reclaim)),
+	)
+
+	var tw tableWriter
+	tw.add("Index", "Table", "Type", "Size", "Scans", "Problem")
+	for _, a := range advice {
+		var sz string
+		if a.idx.Size != -1 {
+			sz = humanize.IBytes(uint64(a.idx.Size))
+		}
+		tw.add(a.idx.DBName+"."+a.idx.SchemaName+"."+a.idx.Name,
+			a.idx.TableName, a.idx.AMName, sz, a.idx.IdxScan, a.problem)
+	}
+	tw.write(fd, "    ")
+
+	fmt.Fprint(fd, `    Suggested Statements:
+`)
+	lastDB := ""
+	for _, a := range advice {
+		if a.idx.DBName != lastDB {
+			fmt.Fprintf(fd, "      -- in database %s\n", a.idx.DBName)
+			lastDB = a.idx.DBName
+		}
+		fmt.Fprintf(fd, "      DROP INDEX CONCURRENTLY %s.%s;\n",
+			quoteIdent(a.idx.SchemaName), quoteIdent(a.idx.Name))
+	}
+}
@@ -19,12 +19,15 @@ package main
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/howeyc/gopass"
 	"github.com/pborman/getopt"
@@ -37,6 +40,7 @@ const usage = `pgmetrics collects PostgreSQL information and metrics.
 
 Usage:
   pgmetrics [OPTION]... [DBNAME]
+  pgmetrics compare-settings [OPTION]... FILE FILE...
 
 General options:
   -t, --timeout=SECS           individual query timeout in seconds (default: 5)
@@ -56,11 +60,15 @@ Collection options:
       --omit=WHAT              do NOT collect the items specified as a comma-separated
                                    list of: "tables", "indexes", "sequences",
                                    "functions", "extensions", "triggers",
-                                   "statements", "log", "citus"
+                                   "statements", "log", "citus",
+                                   "timescaledb"
       --sql-length=LIMIT       collect only first LIMIT characters of all SQL
                                    queries (default: 500)
       --statements-limit=LIMIT collect only utmost LIMIT number of row from
                                    pg_stat_statements (default: 100)
+      --statements-interval=DURATION
+                               also snapshot pg_stat_statements twice, DURATION
+                                   apart (like "30s"), and report the difference
       --only-listed            collect info only from the databases listed as
                                    command-line args (use with Heroku)
       --all-dbs                collect info from all user databases
@@ -68,9 +76,33 @@ Collection options:
       --log-dir                read all the PostgreSQL log files in this directory
       --log-span=MINS          examine the last MINS minutes of logs (default: 5)
       --aws-rds-dbid           AWS RDS/Aurora database instance identifier
+      --follow-replicas        also connect to the upstream and downstream servers
+                                   and collect the replication topology;
+                                   downstream standbys are connected to at
+                                   the same port as -p/--port
+      --replica-hosts=LIST     comma-separated list of HOST[:PORT] of additional
+                                   servers to include in the topology (use
+                                   for standbys on other ports)
+      --sample-activity=DURATION@INTERVAL
+                               sample active backends and their waits every
+                                   INTERVAL for DURATION, like "1m@1s"
+      --buffer-cache           also summarize the contents of shared_buffers
+                                   using the pg_buffercache extension, if
+                                   installed (can be slow on large caches)
+      --exact-bloat            measure bloat of tables and btree indexes larger
+                                   than 10 MiB using the pgstattuple extension,
+                                   instead of estimating it
+      --exact-bloat-time=SECS  time budget per database for --exact-bloat
+                                   (default: 60)
+      --exact-bloat-size=MB    total size of relations to measure per database
+                                   for --exact-bloat (default: 10240)
 
 Output options:
-  -f, --format=FORMAT          output format; "human", "json" or "csv" (default: "human")
+  -f, --format=FORMAT          output format; "human", "json", "csv" or "dot"
+                                   (default: "human")
+      --dot=GRAPH              graph to output for "dot" format; "topology"
+                                   for the replication topology or "locks"
+                                   for the lock graph (default: "topology")
   -l, --toolong=SECS           for human output, transactions running longer than
                                    this are considered too long (default: 60)
   -o, --output=FILE            write output to the specified file
@@ -139,9 +171,13 @@ type options struct {
 	version   bool
 	// output
 	format     string
+	dot        string
 	output     string
 	tooLongSec uint
 	nopager    bool
+	// sampling
+	sampleActivity string
+	stmtsInterval  string
 	// connection
 	passNone bool
 }
@@ -156,6 +192,7 @@ func (o *options) defaults() {
 	o.version = false
 	// output
 	o.format = "human"
+	o.dot = "topology"
 	o.output = ""
 	o.tooLongSec = 60
 	o.nopager = false
@@ -187,6 +224,26 @@ func getRegexp(r string) (err error) {
 	return
 }
 
+// parseSampleSpec parses a "DURATION@INTERVAL" value, where both are Go
+// durations like "30s". The interval defaults to 1s if not specified.
+func parseSampleSpec(spec string) (duration, interval time.Duration, err error) {
+	interval = time.Second
+	ds := spec
+	if pos := strings.IndexByte(spec, '@'); pos >= 0 {
+		ds = spec[:pos]
+		if interval, err = time.ParseDuration(spec[pos+1:]); err != nil {
+			return
+		}
+	}
+	if duration, err = time.ParseDuration(ds); err != nil {
+		return
+	}
+	if interval <= 0 || duration < interval {
+		err = errors.New("duration must be at least as long as the interval, which must be positive")
+	}
+	return
+}
+
 func (o *options) parse() (args []string) {
 	// make getopt
 	s := getopt.New()
@@ -207,14 +264,23 @@ func (o *options) parse() (args []string) {
 	s.ListVarLong(&o.CollectConfig.Omit, "omit", 0, "")
 	s.UintVarLong(&o.CollectConfig.SQLLength, "sql-length", 0, "")
 	s.UintVarLong(&o.CollectConfig.StmtsLimit, "statements-limit", 0, "")
+	s.StringVarLong(&o.stmtsInterval, "statements-interval", 0, "")
 	s.BoolVarLong(&o.CollectConfig.OnlyListedDBs, "only-listed", 0, "").SetFlag()
 	s.BoolVarLong(&o.CollectConfig.AllDBs, "all-dbs", 0, "").SetFlag()
 	s.StringVarLong(&o.CollectConfig.LogFile, "log-file", 0, "")
 	s.StringVarLong(&o.CollectConfig.LogDir, "log-dir", 0, "")
 	s.UintVarLong(&o.CollectConfig.LogSpan, "log-span", 0, "")
 	s.StringVarLong(&o.CollectConfig.RDSDBIdentifier, "aws-rds-dbid", 0, "")
+	s.BoolVarLong(&o.CollectConfig.FollowReplicas, "follow-replicas", 0, "").SetFlag()
+	s.ListVarLong(&o.CollectConfig.ReplicaHosts, "replica-hosts", 0, "")
+	s.StringVarLong(&o.sampleActivity, "sample-activity", 0, "")
+	s.BoolVarLong(&o.CollectConfig.BufferCache, "buffer-cache", 0, "").SetFlag()
+	s.BoolVarLong(&o.CollectConfig.ExactBloat, "exact-bloat", 0, "").SetFlag()
+	s.UintVarLong(&o.CollectConfig.ExactBloatSecs, "exact-bloat-time", 0, "")
+	s.UintVarLong(&o.CollectConfig.ExactBloatMB, "exact-bloat-size", 0, "")
 	// output
 	s.StringVarLong(&o.format, "format", 'f', "")
+	s.StringVarLong(&o.dot, "dot", 0, "")
 	s.StringVarLong(&o.output, "output", 'o', "")
 	s.UintVarLong(&o.tooLongSec, "toolong", 'l', "")
 	s.BoolVarLong(&o.nopager, "no-pager", 0, "").SetFlag()
@@ -236,8 +302,13 @@ func (o *options) parse() (args []string) {
 		printTry()
 		os.Exit(2)
 	}
-	if o.format != "human" && o.format != "json" && o.format != "csv" {
-		fmt.Fprintln(os.Stderr, `option -f/--format must be "human", "json" or "csv"`)
+	if o.format != "human" && o.format != "json" && o.format != "csv" && o.format != "dot" {
+		fmt.Fprintln(os.Stderr, `option -f/--format must be "human", "json", "csv" or "dot"`)
+		printTry()
+		os.Exit(2)
+	}
+	if o.dot != "topology" && o.dot != "locks" {
+		fmt.Fprintln(os.Stderr, `option --dot must be "topology" or "locks"`)
 		printTry()
 		os.Exit(2)
 	}
@@ -279,13 +350,46 @@ func (o *options) parse() (args []string) {
 	for _, om := range o.CollectConfig.Omit {
 		if om != "tables" && om != "indexes" && om != "sequences" &&
 			om != "functions" && om != "extensions" && om != "triggers" &&
-			om != "statements" && om != "log" && om != "citus" {
+			om != "statements" && om != "log" && om != "citus" &&
+			om != "timescaledb" {
 			fmt.Fprintf(os.Stderr, "unknown item \"%s\" in --omit option\n", om)
 			printTry()
 			os.Exit(2)
 		}
 	}
 
+	if o.CollectConfig.ExactBloat && o.CollectConfig.NoSizes {
+		fmt.Fprintln(os.Stderr, "option --exact-bloat cannot be used with -S/--no-sizes")
+		printTry()
+		os.Exit(2)
+	}
+
+	if len(o.CollectConfig.ReplicaHosts) > 0 {
+		o.CollectConfig.FollowReplicas = true
+	}
+	if len(o.sampleActivity) > 0 {
+		d, i, err := parseSampleSpec(o.sampleActivity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bad value for --sample-activity: %v\n", err)
+			printTry()
+			os.Exit(2)
+		}
+		o.CollectConfig.SampleDuration = d
+		o.CollectConfig.SampleInterval = i
+	}
+	if len(o.stmtsInterval) > 0 {
+		d, err := time.ParseDuration(o.stmtsInterval)
+		if err == nil && d <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bad value for --statements-interval: %v\n", err)
+			printTry()
+			os.Exit(2)
+		}
+		o.CollectConfig.StmtsInterval = d
+	}
+
 	// help action
 	if o.helpShort || o.help == "short" || o.help == "variables" {
 		o.usage(0)
@@ -310,6 +414,8 @@ func writeTo(fd io.Writer, o options, result *pgmetrics.Model) {
 		writeJSONTo(fd, result)
 	case "csv":
 		writeCSVTo(fd, result)
+	case "dot":
+		writeDOTTo(fd, o, result)
 	default:
 		writeHumanTo(fd, o, result)
 	}
@@ -323,6 +429,19 @@ func writeJSONTo(fd io.Writer, result *pgmetrics.Model) {
 	}
 }
 
+func writeDOTTo(fd io.Writer, o options, result *pgmetrics.Model) {
+	switch o.dot {
+	case "locks":
+		// an empty graph if no backends are blocked
+		writeBlockingDOT(fd, result)
+	default:
+		if len(result.ReplicationTopology) == 0 {
+			log.Fatal("no replication topology to output, try --follow-replicas")
+		}
+		writeTopologyDOT(fd, result)
+	}
+}
+
 func writeCSVTo(fd io.Writer, result *pgmetrics.Model) {
 	w := csv.NewWriter(fd)
 	if err := model2csv(result, w); err != nil {
@@ -371,11 +490,30 @@ func process(result *pgmetrics.Model, o options, args []string) {
 	}
 }
 
+// readModel reads a previously saved JSON file.
+func readModel(path string) *pgmetrics.Model {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	var obj pgmetrics.Model
+	if err = json.NewDecoder(f).Decode(&obj); err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	return &obj
+}
+
 func main() {
 	for _, e := range ignoreEnvs {
 		os.Unsetenv(e)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "compare-settings" {
+		compareSettingsMain(os.Args[1:])
+		return
+	}
+
 	var o options
 	o.defaults()
 	args := o.parse()
@@ -394,16 +532,7 @@ func main() {
 	// collect or load data
 	var result *pgmetrics.Model
 	if len(o.input) > 0 {
-		f, err := os.Open(o.input)
-		if err != nil {
-			log.Fatal(err)
-		}
-		var obj pgmetrics.Model
-		if err = json.NewDecoder(f).Decode(&obj); err != nil {
-			log.Fatalf("%s: %v", o.input, err)
-		}
-		result = &obj
-		f.Close()
+		result = readModel(o.input)
 	} else {
 		result = collector.Collect(o.CollectConfig, args)
 		// add the user agent
@@ -414,6 +543,18 @@ func main() {
 		}
 	}
 
+	// audit the security-related information, for all output formats
+	result.SecurityFindings = getSecurityFindings(result)
+
+	// arrange the blocked backends into trees, for all output formats
+	result.BlockingTree = getBlockingTree(result)
+
+	// fingerprint the queries, so they can be matched across servers
+	setFingerprints(result)
+
+	// find the tables referenced by the statements
+	setStatementTables(result)
+
 	// process it
 	process(result, o, args)
 }
@@ -0,0 +1,410 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// A partition is lopsided if it is this many times larger than the median of
+// its siblings, and is also larger than the minimums below.
+const (
+	partLopsidedFactor  = 10
+	partLopsidedMinSize = 100 * 1024 * 1024
+	partLopsidedMinRows = 1000000
+)
+
+// partNode is a partitioned table or a partition, in a partition tree.
+type partNode struct {
+	table    *pgmetrics.Table
+	children []*partNode
+}
+
+// partRollup is the sum of the stats of the leaf partitions of a tree.
+type partRollup struct {
+	leaves        int
+	levels        int
+	size          int64 // -1 if the size of any partition is not known
+	seqScan       int64
+	idxScan       int64
+	liveTup       int64
+	deadTup       int64
+	maxXidAge     int
+	oldestVacuum  int64 // oldest of the last vacuums of the partitions
+	neverVacuumed int
+}
+
+// splitRegclass splits the text form of a regclass, like `s."T"`, into the
+// schema (which may be empty) and the name.
+func splitRegclass(name string) (schema, rel string) {
+	var parts []string
+	var cur strings.Builder
+	quoted := false
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch == '"' && quoted && i+1 < len(name) && name[i+1] == '"':
+			cur.WriteByte('"')
+			i++
+		case ch == '"':
+			quoted = !quoted
+		case ch == '.' && !quoted:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(ch)
+		}
+	}
+	parts = append(parts, cur.String())
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// findParent returns the table that is the parent of the given partition.
+// The parent's name is schema-qualified only if it is not in the search path
+// of the collecting session, so prefer a parent in the same schema.
+func findParent(tables []*pgmetrics.Table, t *pgmetrics.Table) *pgmetrics.Table {
+	schema, name := splitRegclass(t.ParentName)
+	var found *pgmetrics.Table
+	for _, p := range tables {
+		if p.Name != name || (len(schema) > 0 && p.SchemaName != schema) {
+			continue
+		}
+		if p.SchemaName == t.SchemaName || len(schema) > 0 {
+			return p
+		}
+		found = p
+	}
+	return found
+}
+
+// getPartitionTrees returns the partition trees of the given tables, with
+// the partitioned tables at the root. Before Postgres 14, partitioned tables
+// are not listed in pg_stat_user_tables and so are not collected. In this
+// case, the parent is made up from the ParentName of its partitions. The
+// parent of such a parent is not known either, so each level of a
+// multi-level tree is then reported as a tree of its own.
+func getPartitionTrees(tables []*pgmetrics.Table) (roots []*partNode) {
+	nodes := make(map[*pgmetrics.Table]*partNode)
+	get := func(t *pgmetrics.Table) *partNode {
+		n, ok := nodes[t]
+		if !ok {
+			n = &partNode{table: t}
+			nodes[t] = n
+		}
+		return n
+	}
+	hasParent := make(map[*pgmetrics.Table]bool)
+	madeUp := make(map[string]*pgmetrics.Table)
+	var madeUpList []*pgmetrics.Table
+	for _, t := range tables {
+		if !t.RelIsPartition || len(t.ParentName) == 0 {
+			continue
+		}
+		p := findParent(tables, t)
+		if p == nil {
+			schema, name := splitRegclass(t.ParentName)
+			if len(schema) == 0 {
+				schema = t.SchemaName
+			}
+			key := schema + "." + name
+			if p = madeUp[key]; p == nil {
+				p = &pgmetrics.Table{DBName: t.DBName, SchemaName: schema,
+					Name: name, RelKind: "p", Size: -1, Bloat: -1}
+				madeUp[key] = p
+				madeUpList = append(madeUpList, p)
+			}
+		}
+		get(p).children = append(get(p).children, get(t))
+		hasParent[t] = true
+	}
+	for _, t := range tables {
+		if n, ok := nodes[t]; ok && !hasParent[t] {
+			roots = append(roots, n)
+		}
+	}
+	for _, t := range madeUpList {
+		roots = append(roots, nodes[t])
+	}
+	for _, n := range nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].table.Name < n.children[j].table.Name
+		})
+	}
+	return
+}
+
+func (r *partRollup) add(n *partNode, level int) {
+	if level > r.levels {
+		r.levels = level
+	}
+	if len(n.children) > 0 {
+		for _, c := range n.children {
+			r.add(c, level+1)
+		}
+		return
+	}
+	t := n.table
+	r.leaves++
+	if t.Size < 0 || r.size < 0 {
+		r.size = -1
+	} else {
+		r.size += t.Size
+	}
+	r.seqScan += t.SeqScan
+	r.idxScan += t.IdxScan
+	r.liveTup += t.NLiveTup
+	r.deadTup += t.NDeadTup
+	if t.AgeRelFrozenXid > r.maxXidAge {
+		r.maxXidAge = t.AgeRelFrozenXid
+	}
+	last := t.LastVacuum
+	if t.LastAutovacuum > last {
+		last = t.LastAutovacuum
+	}
+	if last == 0 {
+		r.neverVacuumed++
+	} else if r.oldestVacuum == 0 || last < r.oldestVacuum {
+		r.oldestVacuum = last
+	}
+}
+
+func getPartRollup(n *partNode) *partRollup {
+	var r partRollup
+	r.add(n, 0)
+	return &r
+}
+
+// getPartitionRollups returns the rollups of all partitioned tables in the
+// database, including the intermediate ones of multi-level trees.
+func getPartitionRollups(result *pgmetrics.Model, db string) map[*pgmetrics.Table]*partRollup {
+	out := make(map[*pgmetrics.Table]*partRollup)
+	var walk func(n *partNode)
+	walk = func(n *partNode) {
+		if len(n.children) == 0 {
+			return
+		}
+		out[n.table] = getPartRollup(n)
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	for _, n := range getPartitionTrees(filterTablesByDB(result, db)) {
+		walk(n)
+	}
+	return out
+}
+
+//------------------------------------------------------------------------------
+// partition health
+
+var rxRangeBound = regexp.MustCompile(`^FOR VALUES FROM \('([^']*)'\) TO \('([^']*)'\)$`)
+
+var rangeBoundLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05-07",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+}
+
+func parseRangeBound(s string) (time.Time, bool) {
+	for _, layout := range rangeBoundLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// getTimeRange returns the bounds of the partition, if it is a partition of
+// a table range partitioned on a single date or timestamp column.
+func getTimeRange(t *pgmetrics.Table) (from, to time.Time, ok bool) {
+	sm := rxRangeBound.FindStringSubmatch(t.PartitionCV)
+	if sm == nil {
+		return
+	}
+	if from, ok = parseRangeBound(sm[1]); !ok {
+		return
+	}
+	to, ok = parseRangeBound(sm[2])
+	return
+}
+
+// partProblem is a problem found with a partitioned table.
+type partProblem struct {
+	table    string
+	severity string
+	problem  string
+}
+
+func partName(t *pgmetrics.Table) string {
+	return t.DBName + "." + t.SchemaName + "." + t.Name
+}
+
+// partSizeOf returns the size of the partition, or the number of live rows
+// if the sizes are not known.
+func partSizeOf(t *pgmetrics.Table, bySize bool) int64 {
+	if bySize {
+		return t.Size
+	}
+	return t.NLiveTup
+}
+
+// getPartitionProblems checks the direct partitions of the partitioned table
+// n for default partitions with rows, lopsided partitions and missing future
+// time range partitions.
+func getPartitionProblems(n *partNode, now time.Time) (out []partProblem) {
+	name := partName(n.table)
+	var others []*pgmetrics.Table
+	for _, c := range n.children {
+		t := c.table
+		if t.PartitionCV == "DEFAULT" {
+			if t.NLiveTup > 0 {
+				out = append(out, partProblem{name, sevWarning, fmt.Sprintf(
+					"default partition %s has ~%d rows, which do not belong to any other partition",
+					t.Name, t.NLiveTup)})
+			}
+			continue
+		}
+		others = append(others, t)
+	}
+
+	// lopsided partitions, among leaf partitions
+	var leaves []*pgmetrics.Table
+	bySize := true
+	for _, c := range n.children {
+		if len(c.children) == 0 && c.table.PartitionCV != "DEFAULT" {
+			leaves = append(leaves, c.table)
+			if c.table.Size < 0 {
+				bySize = false
+			}
+		}
+	}
+	if len(leaves) >= 3 {
+		vals := make([]int64, len(leaves))
+		for i, t := range leaves {
+			vals[i] = partSizeOf(t, bySize)
+		}
+		sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+		median := vals[len(vals)/2]
+		for _, t := range leaves {
+			v := partSizeOf(t, bySize)
+			if median <= 0 || v < partLopsidedFactor*median {
+				continue
+			}
+			if bySize && v >= partLopsidedMinSize {
+				out = append(out, partProblem{name, sevWarning, fmt.Sprintf(
+					"partition %s is %s, %.0fx the median partition size of %s",
+					t.Name, humanize.IBytes(uint64(v)), float64(v)/float64(median),
+					humanize.IBytes(uint64(median)))})
+			} else if !bySize && v >= partLopsidedMinRows {
+				out = append(out, partProblem{name, sevWarning, fmt.Sprintf(
+					"partition %s has ~%d rows, %.0fx the median of %d rows",
+					t.Name, v, float64(v)/float64(median), median)})
+			}
+		}
+	}
+
+	// time range partitions that do not extend into the future
+	if len(others) == 0 {
+		return
+	}
+	var latestFrom, latestTo time.Time
+	for _, t := range others {
+		from, to, ok := getTimeRange(t)
+		if !ok {
+			return // not range partitioned on a date or time
+		}
+		if to.After(latestTo) {
+			latestFrom, latestTo = from, to
+		}
+	}
+	if !latestTo.After(now) {
+		out = append(out, partProblem{name, sevCritical, fmt.Sprintf(
+			"no partition for the current time, the latest one ends at %s",
+			latestTo.Format("2006-01-02 15:04:05"))})
+	} else if !latestFrom.After(now) {
+		out = append(out, partProblem{name, sevWarning, fmt.Sprintf(
+			"no future partition has been created, the current one ends at %s",
+			latestTo.Format("2006-01-02 15:04:05"))})
+	}
+	return
+}
+
+func fmtOldestVacuum(r *partRollup) string {
+	if r.neverVacuumed > 0 {
+		return fmt.Sprintf("never (%d partitions)", r.neverVacuumed)
+	}
+	return fmtTime(r.oldestVacuum)
+}
+
+func reportPartitions(fd io.Writer, result *pgmetrics.Model) {
+	now := time.Unix(result.Metadata.At, 0)
+	var tw, tw2 tableWriter
+	tw.add("Table", "Partitions", "Levels", "Size", "Seq Scans", "Idx Scans",
+		"Live Rows", "Dead Rows", "Max Xid Age", "Oldest Vacuum")
+	tw2.add("Table", "Severity", "Problem")
+	for _, db := range result.Metadata.CollectedDBs {
+		var walk func(n *partNode)
+		walk = func(n *partNode) {
+			if len(n.children) == 0 {
+				return
+			}
+			for _, p := range getPartitionProblems(n, now) {
+				tw2.add(p.table, p.severity, p.problem)
+			}
+			for _, c := range n.children {
+				walk(c)
+			}
+		}
+		for _, n := range getPartitionTrees(filterTablesByDB(result, db)) {
+			r := getPartRollup(n)
+			var size string
+			if r.size >= 0 {
+				size = humanize.IBytes(uint64(r.size))
+			}
+			tw.add(partName(n.table), r.leaves, r.levels, size, r.seqScan,
+				r.idxScan, r.liveTup, r.deadTup, r.maxXidAge, fmtOldestVacuum(r))
+			walk(n)
+		}
+	}
+	if len(tw.data) == 1 {
+		return
+	}
+
+	fmt.Fprint(fd, `
+Partitioned Tables:
+`)
+	tw.write(fd, "    ")
+	if len(tw2.data) > 1 {
+		fmt.Fprintln(fd, "    Problems:")
+		tw2.write(fd, "      ")
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// progress is the state of a command in progress, as shown in the report.
+type progress struct {
+	title    string
+	pid      int
+	phase    string
+	dbName   string
+	target   []string // label and value pairs, like "Table:", "public.t"
+	done     int64
+	total    int64 // 0 if not known
+	unit     string
+	bytes    bool
+	extra    []string // more label and value pairs
+	start    int64
+	estimate bool // the rate so far is a fair estimate of the rest
+}
+
+func (p *progress) add(label, value string) {
+	p.extra = append(p.extra, label, value)
+}
+
+func fmtProgressCount(v int64, unit string, bytes bool) string {
+	if bytes {
+		return humanize.IBytes(uint64(v))
+	}
+	return fmt.Sprintf("%d %s", v, unit)
+}
+
+// fmtProgress returns the amount of work done, and the percentage of the
+// total if it is known.
+func fmtProgress(p *progress) string {
+	if p.total <= 0 {
+		return fmtProgressCount(p.done, p.unit, p.bytes)
+	}
+	done := fmtProgressCount(p.done, p.unit, p.bytes)
+	if !p.bytes {
+		done = fmt.Sprintf("%d", p.done)
+	}
+	return fmt.Sprintf("%s of %s (%.1f%% complete)", done,
+		fmtProgressCount(p.total, p.unit, p.bytes),
+		100*safeDiv(p.done, p.total))
+}
+
+// fmtRemaining estimates the time remaining for the command, assuming that
+// it continues at the same rate as it has so far.
+func fmtRemaining(p *progress, now int64) string {
+	if !p.estimate || p.done <= 0 || p.total <= 0 || p.done > p.total ||
+		p.start <= 0 || now <= p.start {
+		return ""
+	}
+	return fmtETA(p.total-p.done, float64(p.done)/float64(now-p.start))
+}
+
+func createIndexProgress(ci *pgmetrics.CreateIndexProgressBackend) *progress {
+	p := &progress{
+		title:  ci.Command,
+		pid:    ci.PID,
+		phase:  ci.Phase,
+		dbName: ci.DBName,
+		target: []string{"Table:", ci.TableName, "Index:", ci.IndexName},
+		start:  ci.QueryStart,
+	}
+	switch {
+	case strings.HasPrefix(ci.Phase, "waiting") && ci.LockersTotal > 0:
+		p.done, p.total, p.unit = ci.LockersDone, ci.LockersTotal, "lockers"
+		if ci.CurrentLockerPID != 0 {
+			p.add("Waiting For:", fmt.Sprintf("pid %d", ci.CurrentLockerPID))
+		}
+	case ci.TuplesTotal > 0:
+		p.done, p.total, p.unit = ci.TuplesDone, ci.TuplesTotal, "tuples"
+	default:
+		p.done, p.total, p.unit = ci.BlocksDone, ci.BlocksTotal, "blocks"
+		// the initial table scan is usually the bulk of the work
+		p.estimate = ci.Phase == "building index: scanning table"
+	}
+	if ci.PartitionsTotal > 0 {
+		p.add("Partitions:", fmt.Sprintf("%d of %d done", ci.PartitionsDone,
+			ci.PartitionsTotal))
+	}
+	return p
+}
+
+func clusterProgress(cl *pgmetrics.ClusterProgressBackend) *progress {
+	p := &progress{
+		title:  cl.Command,
+		pid:    cl.PID,
+		phase:  cl.Phase,
+		dbName: cl.DBName,
+		target: []string{"Table:", cl.TableName},
+		start:  cl.QueryStart,
+	}
+	if cl.HeapBlksTotal > 0 {
+		p.done, p.total, p.unit = cl.HeapBlksScanned, cl.HeapBlksTotal, "blocks"
+		p.estimate = cl.Phase == "seq scanning heap"
+	} else {
+		p.done, p.unit = cl.HeapTuplesScanned, "tuples scanned"
+	}
+	p.add("Tuples Written:", fmt.Sprintf("%d", cl.HeapTuplesWritten))
+	if cl.IndexRebuildCount > 0 {
+		p.add("Indexes Rebuilt:", fmt.Sprintf("%d", cl.IndexRebuildCount))
+	}
+	return p
+}
+
+func analyzeProgress(an *pgmetrics.AnalyzeProgressBackend) *progress {
+	p := &progress{
+		title:  "ANALYZE",
+		pid:    an.PID,
+		phase:  an.Phase,
+		dbName: an.DBName,
+		target: []string{"Table:", an.TableName},
+		start:  an.QueryStart,
+	}
+	switch an.Phase {
+	case "computing extended statistics":
+		p.done, p.total, p.unit = an.ExtStatsComputed, an.ExtStatsTotal, "statistics"
+	case "acquiring inherited sample rows":
+		p.done, p.total, p.unit = an.ChildTablesDone, an.ChildTablesTotal, "child tables"
+	default:
+		p.done, p.total, p.unit = an.SampleBlksScanned, an.SampleBlksTotal, "blocks"
+		p.estimate = an.Phase == "acquiring sample rows"
+	}
+	return p
+}
+
+func basebackupProgress(bb *pgmetrics.BasebackupProgressBackend) *progress {
+	p := &progress{
+		title:    "BASE BACKUP",
+		pid:      bb.PID,
+		phase:    bb.Phase,
+		done:     bb.BackupStreamed,
+		total:    bb.BackupTotal,
+		bytes:    true,
+		start:    bb.BackendStart,
+		estimate: true,
+	}
+	if bb.TablespacesTotal > 0 {
+		p.add("Tablespaces:", fmt.Sprintf("%d of %d streamed",
+			bb.TablespacesStreamed, bb.TablespacesTotal))
+	}
+	return p
+}
+
+func copyProgress(cp *pgmetrics.CopyProgressBackend) *progress {
+	p := &progress{
+		title:    cp.Command,
+		pid:      cp.PID,
+		dbName:   cp.DBName,
+		target:   []string{"Table:", cp.TableName},
+		done:     cp.BytesProcessed,
+		total:    cp.BytesTotal,
+		bytes:    true,
+		start:    cp.QueryStart,
+		estimate: true,
+	}
+	if len(cp.Type) > 0 {
+		p.title += " " + cp.Type
+	}
+	p.add("Tuples:", fmt.Sprintf("%d processed, %d excluded",
+		cp.TuplesProcessed, cp.TuplesExcluded))
+	return p
+}
+
+func getProgress(result *pgmetrics.Model) (out []*progress) {
+	for i := range result.CreateIndexProgress {
+		out = append(out, createIndexProgress(&result.CreateIndexProgress[i]))
+	}
+	for i := range result.ClusterProgress {
+		out = append(out, clusterProgress(&result.ClusterProgress[i]))
+	}
+	for i := range result.AnalyzeProgress {
+		out = append(out, analyzeProgress(&result.AnalyzeProgress[i]))
+	}
+	for i := range result.BasebackupProgress {
+		out = append(out, basebackupProgress(&result.BasebackupProgress[i]))
+	}
+	for i := range result.CopyProgress {
+		out = append(out, copyProgress(&result.CopyProgress[i]))
+	}
+	return
+}
+
+func reportProgress(fd io.Writer, result *pgmetrics.Model) {
+	list := getProgress(result)
+	if len(list) == 0 {
+		return
+	}
+	now := result.Metadata.At
+
+	fmt.Fprint(fd, `
+Command Progress:`)
+	for _, p := range list {
+		fmt.Fprintf(fd, `
+    %s (pid %d):`, p.title, p.pid)
+		line := func(label, value string) {
+			if len(value) > 0 {
+				fmt.Fprintf(fd, "\n      %-19s%s", label, value)
+			}
+		}
+		line("Phase:", p.phase)
+		line("Database:", p.dbName)
+		for i := 0; i+1 < len(p.target); i += 2 {
+			line(p.target[i], p.target[i+1])
+		}
+		line("Progress:", fmtProgress(p))
+		for i := 0; i+1 < len(p.extra); i += 2 {
+			line(p.extra[i], p.extra[i+1])
+		}
+		line("Running For:", fmtAge(p.start, now))
+		line("Est. Remaining:", fmtRemaining(p, now))
+	}
+	fmt.Fprintln(fd)
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -123,19 +124,40 @@ PostgreSQL Cluster:
 
 	if len(result.ReplicationSlots) > 0 {
 		reportReplicationSlots(fd, result, version)
+		reportSlotHealth(fd, result)
 	}
 
+	reportTopology(fd, result)
+
 	reportWAL(fd, result, version)
 	reportBGWriter(fd, result)
 	reportBackends(fd, o.tooLongSec, result)
+	reportSSL(fd, result)
 	reportLocks(fd, result)
+	reportBlockingTree(fd, result)
+	reportActivitySamples(fd, result)
+	reportWorkload(fd, result)
+	reportFingerprints(fd, result)
+	reportStatementsInterval(fd, result)
+	reportXminHorizon(fd, result)
 	if version >= 90600 {
 		reportVacuumProgress(fd, result)
 	}
+	reportProgress(fd, result)
 	reportRoles(fd, result)
+	reportHBA(fd, result)
+	reportSecurity(fd, result)
 	reportTablespaces(fd, result)
 	reportDatabases(fd, result)
 	reportTables(fd, result)
+	reportPartitions(fd, result)
+	reportTimescaleDB(fd, result)
+	reportBufferCache(fd, result)
+	reportAutovacuum(fd, result)
+	reportIndexAdvice(fd, result)
+	reportConstraints(fd, result)
+	reportPendingSettings(fd, result)
+	reportConfigAdvice(fd, result)
 	fmt.Fprintln(fd)
 }
 
@@ -233,6 +255,7 @@ Physical Replication Slots:
 		if version >= 100000 {
 			cols = append(cols, "Temporary")
 		}
+		cols = append(cols, slotHealthCols(result, version)...)
 		tw.add(cols...)
 		for _, r := range result.ReplicationSlots {
 			if r.SlotType != "physical" {
@@ -243,6 +266,7 @@ Physical Replication Slots:
 			if version >= 100000 {
 				vals = append(vals, fmtYesNo(r.Temporary))
 			}
+			vals = append(vals, slotHealthVals(result, version, &r)...)
 			tw.add(vals...)
 		}
 		tw.write(fd, "    ")
@@ -257,6 +281,7 @@ Logical Replication Slots:
 		if version >= 100000 {
 			cols = append(cols, "Temporary")
 		}
+		cols = append(cols, slotHealthCols(result, version)...)
 		tw.add(cols...)
 		for _, r := range result.ReplicationSlots {
 			if r.SlotType != "logical" {
@@ -268,6 +293,7 @@ Logical Replication Slots:
 			if version >= 100000 {
 				vals = append(vals, fmtYesNo(r.Temporary))
 			}
+			vals = append(vals, slotHealthVals(result, version, &r)...)
 			tw.add(vals...)
 		}
 		tw.write(fd, "    ")
@@ -872,6 +898,7 @@ Database #%d:
 					s.ReceivedLSN,
 					fmtMicros(s.Latency),
 				)
+				reportSubscriptionDetail(fd, result, s)
 			}
 			gap = true
 		}
@@ -1111,11 +1138,13 @@ func filterIndexesByTable(result *pgmetrics.Model, db, schema, table string) (ou
 }
 
 func reportTables(fd io.Writer, result *pgmetrics.Model) {
+	workloads, stmtTime := getTableWorkloads(result)
 	for _, db := range result.Metadata.CollectedDBs {
 		tables := filterTablesByDB(result, db)
 		if len(tables) == 0 {
 			continue
 		}
+		rollups := getPartitionRollups(result, db)
 		for i, t := range tables {
 			nTup := t.NLiveTup + t.NDeadTup
 			nTupChanged := t.NTupIns + t.NTupUpd + t.NTupDel
@@ -1139,10 +1168,33 @@ Table #%d in "%s":
     Inherits from:       %s`, t.ParentName)
 				}
 			}
+			if r, ok := rollups[t]; ok {
+				fmt.Fprintf(fd, `
+    Partitions:          %d, %d rows live, %d dead`, r.leaves, r.liveTup, r.deadTup)
+				if r.size >= 0 {
+					fmt.Fprintf(fd, ", %s", humanize.IBytes(uint64(r.size)))
+				}
+			}
 			if len(t.TablespaceName) > 0 {
 				fmt.Fprintf(fd, `
     Tablespace:          %s`, t.TablespaceName)
 			}
+			if len(t.RelOptions) > 0 {
+				fmt.Fprintf(fd, `
+    Storage Options:     %s`, strings.Join(t.RelOptions, ", "))
+			}
+			if t.RelKind == "r" || t.RelKind == "m" {
+				av := getAVThresholds(result, t)
+				fmt.Fprintf(fd, `
+    Autovacuum At:       %d dead rows`, av.vacuumAt)
+				if av.insertAt >= 0 {
+					fmt.Fprintf(fd, " or %d rows inserted", av.insertAt)
+				}
+				fmt.Fprintf(fd, ", analyze at %d rows modified", av.analyzeAt)
+				if status := fmtAVStatus(av); len(status) > 0 {
+					fmt.Fprintf(fd, " (%s)", status)
+				}
+			}
 			fmt.Fprintf(fd, `
     Columns:             %d
     Manual Vacuums:      %s
@@ -1175,6 +1227,7 @@ Table #%d in "%s":
 						t.TidxBlksHit+t.TidxBlksRead),
 				100*safeDiv(t.IdxBlksHit, t.IdxBlksHit+t.IdxBlksRead),
 			)
+			reportTableWorkload(fd, workloads[db+"."+t.SchemaName+"."+t.Name], stmtTime)
 			if t.Size != -1 {
 				fmt.Fprintf(fd, `
     Size:                %s`, humanize.IBytes(uint64(t.Size)))
@@ -1182,12 +1235,14 @@ Table #%d in "%s":
 			if t.Bloat != -1 {
 				if t.Size != -1 {
 					fmt.Fprintf(fd, `
-    Bloat:               %s (%.1f%%)`,
+    Bloat:               %s (%.1f%%)%s`,
 						humanize.IBytes(uint64(t.Bloat)),
-						100*safeDiv(t.Bloat, t.Size))
+						100*safeDiv(t.Bloat, t.Size),
+						fmtBloatMethod(t.BloatMethod))
 				} else {
 					fmt.Fprintf(fd, `
-    Bloat:               %s`, humanize.IBytes(uint64(t.Bloat)))
+    Bloat:               %s%s`, humanize.IBytes(uint64(t.Bloat)),
+						fmtBloatMethod(t.BloatMethod))
 				}
 			}
 			if acls := parseACL(t.ACL); len(acls) > 0 {
@@ -1222,6 +1277,7 @@ Table #%d in "%s":
 					} else {
 						bloat = humanize.IBytes(uint64(idx.Bloat))
 					}
+					bloat += fmtBloatMethod(idx.BloatMethod)
 				}
 				tw.add(
 					idx.Name,
@@ -1239,6 +1295,16 @@ Table #%d in "%s":
 	}
 }
 
+// fmtBloatMethod returns a suffix for bloat values that were measured rather
+// than estimated.
+func fmtBloatMethod(method string) string {
+	if method == pgmetrics.BloatMethodPgstattupleApprox ||
+		method == pgmetrics.BloatMethodPgstatindex {
+		return ", measured"
+	}
+	return ""
+}
+
 func tableAttrs(t *pgmetrics.Table) string {
 	var parts []string
 	if t.RelPersistence == "u" {
@@ -1527,6 +1593,18 @@ func getSettingInt(result *pgmetrics.Model, key string) int {
 	return val
 }
 
+func getSettingFloat(result *pgmetrics.Model, key string) float64 {
+	s := getSetting(result, key)
+	if len(s) == 0 {
+		return 0
+	}
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return val
+}
+
 func getSettingBytes(result *pgmetrics.Model, key string, factor uint64) string {
 	s := getSetting(result, key)
 	if len(s) == 0 {
@@ -1546,6 +1624,15 @@ func safeDiv(a, b int64) float64 {
 	return float64(a) / float64(b)
 }
 
+func arrayHas(arr []string, val string) bool {
+	for _, elem := range arr {
+		if elem == val {
+			return true
+		}
+	}
+	return false
+}
+
 func lsn2int(s string) int64 {
 	if len(s) == 0 {
 		return -1
@@ -1591,6 +1678,32 @@ func getVersion(result *pgmetrics.Model) int {
 	return v
 }
 
+// schemaAtLeast returns true if the model was created by a pgmetrics that
+// uses schema version major.minor or later.
+func schemaAtLeast(result *pgmetrics.Model, major, minor int) bool {
+	parts := strings.SplitN(result.Metadata.Version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	ma, err1 := strconv.Atoi(parts[0])
+	mi, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return ma > major || (ma == major && mi >= minor)
+}
+
+// quoteIdent quotes the identifier s if it is not a plain lowercase
+// identifier. Unlike quote_ident(), keywords are not checked for.
+func quoteIdent(s string) string {
+	if rxPlainIdent.MatchString(s) {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+var rxPlainIdent = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
 func getMaxWalSize(result *pgmetrics.Model) (key, val string) {
 	if version := getVersion(result); version >= 100000 {
 		key = "max_wal_size"
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Passwords expiring within this duration are reported.
+const passwordExpiryWarning = 7 * 24 * time.Hour
+
+// bootstrapSuperuserOID is the OID of the role created by initdb.
+const bootstrapSuperuserOID = 10
+
+func getRole(result *pgmetrics.Model, name string) *pgmetrics.Role {
+	for i := range result.Roles {
+		if result.Roles[i].Name == name {
+			return &result.Roles[i]
+		}
+	}
+	return nil
+}
+
+// roleGroups returns the set of roles that the named role is directly or
+// indirectly a member of, including itself.
+func roleGroups(result *pgmetrics.Model, name string) map[string]bool {
+	groups := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		r := getRole(result, queue[0])
+		queue = queue[1:]
+		if r == nil {
+			continue
+		}
+		for _, m := range r.MemberOf {
+			if !groups[m] {
+				groups[m] = true
+				queue = append(queue, m)
+			}
+		}
+	}
+	return groups
+}
+
+// sharesGroup checks if the two roles are the same, or if either is a member
+// of the other, or if both are members of a common group.
+func sharesGroup(result *pgmetrics.Model, a, b string) bool {
+	ga, gb := roleGroups(result, a), roleGroups(result, b)
+	for g := range ga {
+		if gb[g] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPriv(item aclItem, priv string) bool {
+	return arrayHas(item.privs, priv)
+}
+
+// isPublicCreateAllowed checks if PUBLIC can create objects in the schema.
+// Before v15, the public schema allowed this by default.
+func isPublicCreateAllowed(result *pgmetrics.Model, s *pgmetrics.Schema) bool {
+	if len(s.ACL) == 0 {
+		v := getVersion(result)
+		return s.Name == "public" && v > 0 && v < 150000
+	}
+	for _, item := range parseACL(s.ACL) {
+		if item.role == "PUBLIC" && hasPriv(item, "CREATE") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicExecuteAllowed checks if PUBLIC can execute the function, which is
+// the default for functions.
+func isPublicExecuteAllowed(f *pgmetrics.SecurityDefiner) bool {
+	if len(f.ACL) == 0 {
+		return true
+	}
+	for _, item := range parseACL(f.ACL) {
+		if item.role == "PUBLIC" && hasPriv(item, "EXECUTE") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSearchPath(config []string) bool {
+	for _, c := range config {
+		if strings.HasPrefix(c, "search_path=") {
+			return true
+		}
+	}
+	return false
+}
+
+// getSecurityFindings audits the roles, privileges and ACLs in the model.
+func getSecurityFindings(result *pgmetrics.Model) (out []pgmetrics.SecurityFinding) {
+	add := func(sev, category, object, detail string) {
+		out = append(out, pgmetrics.SecurityFinding{
+			Severity: sev,
+			Category: category,
+			Object:   object,
+			Detail:   detail,
+		})
+	}
+
+	// roles
+	var noExpiry int
+	for i := range result.Roles {
+		r := &result.Roles[i]
+		switch {
+		case r.Rolsuper && r.Rolcanlogin && r.OID != bootstrapSuperuserOID:
+			add(sevWarning, "superuser", r.Name, "can log in as superuser")
+		case r.Rolsuper:
+			add(sevInfo, "superuser", r.Name, "is a superuser")
+		case r.Rolreplication && r.Rolcanlogin:
+			add(sevInfo, "replication", r.Name, "can log in for replication, and read all data")
+		}
+		if r.Rolbypassrls && !r.Rolsuper {
+			add(sevInfo, "bypassrls", r.Name, "bypasses row level security policies")
+		}
+		if !r.Rolcanlogin {
+			continue
+		}
+		if r.Rolvaliduntil == 0 {
+			noExpiry++ // too common to list each one, summarized below
+		} else if r.Rolvaliduntil < result.Metadata.At {
+			add(sevWarning, "password", r.Name, "password expired at "+fmtTime(r.Rolvaliduntil))
+		} else if time.Duration(r.Rolvaliduntil-result.Metadata.At)*time.Second < passwordExpiryWarning {
+			add(sevWarning, "password", r.Name, "password expires at "+fmtTime(r.Rolvaliduntil))
+		}
+	}
+
+	if noExpiry == 1 {
+		add(sevInfo, "password", "1 login role", "has no password expiry (VALID UNTIL not set)")
+	} else if noExpiry > 1 {
+		add(sevInfo, "password", fmt.Sprintf("%d login roles", noExpiry),
+			"have no password expiry (VALID UNTIL not set)")
+	}
+
+	// schemas
+	for i := range result.Schemas {
+		s := &result.Schemas[i]
+		name := s.DBName + "." + s.Name
+		if isPublicCreateAllowed(result, s) {
+			add(sevWarning, "public create", name, "PUBLIC can create objects in this schema")
+		}
+	}
+
+	// security definer functions
+	for i := range result.SecurityDefiners {
+		f := &result.SecurityDefiners[i]
+		if hasSearchPath(f.Config) {
+			continue
+		}
+		name := fmt.Sprintf("%s.%s.%s(%s)", f.DBName, f.SchemaName, f.Name, f.Args)
+		detail := "SECURITY DEFINER function owned by " + f.Owner + " does not set search_path"
+		sev := sevWarning
+		if isPublicExecuteAllowed(f) {
+			detail += ", and is executable by PUBLIC"
+			if r := getRole(result, f.Owner); r != nil && r.Rolsuper {
+				sev = sevCritical
+			}
+		}
+		add(sev, "security definer", name, detail)
+	}
+
+	// tables
+	for i := range result.Tables {
+		t := &result.Tables[i]
+		if len(t.ACL) == 0 {
+			continue
+		}
+		name := t.DBName + "." + t.SchemaName + "." + t.Name
+		var outsiders []string
+		for _, item := range parseACL(t.ACL) {
+			if item.role == "PUBLIC" {
+				add(sevWarning, "public grant", name,
+					"PUBLIC has "+strings.Join(item.privs, ", "))
+				continue
+			}
+			role := unquoteIdent(item.role)
+			if len(t.Owner) > 0 && hasPriv(item, "SELECT") && !sharesGroup(result, role, t.Owner) {
+				outsiders = append(outsiders, role)
+			}
+		}
+		if len(outsiders) > 0 {
+			add(sevInfo, "table access", name, "readable by "+strings.Join(outsiders, ", ")+
+				", which share no group with owner "+t.Owner)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return severityRank(out[i].Severity) > severityRank(out[j].Severity)
+	})
+	return
+}
+
+func reportSecurity(fd io.Writer, result *pgmetrics.Model) {
+	if len(result.SecurityFindings) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, f := range result.SecurityFindings {
+		counts[f.Severity]++
+	}
+	fmt.Fprintf(fd, `
+Security Audit:
+    Findings:            %d critical, %d warning, %d info
+`,
+		counts[sevCritical], counts[sevWarning], counts[sevInfo])
+
+	var tw tableWriter
+	tw.add("Severity", "Category", "Object", "Detail")
+	for _, f := range result.SecurityFindings {
+		tw.add(f.Severity, f.Category, f.Object, f.Detail)
+	}
+	tw.write(fd, "    ")
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// getPendingValue returns the value from the configuration files that will
+// take effect for the setting name after a restart, if known.
+func getPendingValue(result *pgmetrics.Model, name string) string {
+	var val string
+	for _, f := range result.FileSettingErrors {
+		if f.Name == name && !f.Applied {
+			val = f.Setting // later entries override earlier ones
+		}
+	}
+	return val
+}
+
+func reportPendingSettings(fd io.Writer, result *pgmetrics.Model) {
+	var names []string
+	for name, s := range result.Settings {
+		if s.PendingRestart {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		fmt.Fprint(fd, `
+Settings Pending Restart:
+`)
+		var tw tableWriter
+		tw.add("Setting", "Current", "Pending", "Unit", "Context")
+		for _, name := range names {
+			s := result.Settings[name]
+			tw.add(name, getSettingValue(result, name).display,
+				getPendingValue(result, name), s.Unit, s.Context)
+		}
+		tw.write(fd, "    ")
+	}
+
+	if len(result.FileSettingErrors) > 0 {
+		fmt.Fprint(fd, `
+Configuration File Errors:
+`)
+		var tw tableWriter
+		tw.add("File", "Line", "Setting", "Value", "Applied?", "Error")
+		for _, f := range result.FileSettingErrors {
+			tw.add(f.SourceFile, f.SourceLine, f.Name, f.Setting,
+				fmtYesNo(f.Applied), f.Error)
+		}
+		tw.write(fd, "    ")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// slotHealthCols returns the extra column headers for the replication slot
+// tables, depending on what is available in the model.
+func slotHealthCols(result *pgmetrics.Model, version int) (cols []interface{}) {
+	if !schemaAtLeast(result, 1, 11) {
+		return
+	}
+	cols = append(cols, "Retained WAL")
+	if version >= 130000 {
+		cols = append(cols, "WAL Status", "Safe WAL Size")
+	}
+	return
+}
+
+func slotHealthVals(result *pgmetrics.Model, version int, r *pgmetrics.ReplicationSlot) (vals []interface{}) {
+	if !schemaAtLeast(result, 1, 11) {
+		return
+	}
+	var retained string
+	if r.RetainedWAL > 0 {
+		retained = humanize.IBytes(uint64(r.RetainedWAL))
+	}
+	vals = append(vals, retained)
+	if version >= 130000 {
+		// safe_wal_size is null if the slot is lost or if there is no limit
+		var safe string
+		if r.WALStatus == "lost" {
+			safe = "lost"
+		} else if r.SafeWALSize != nil && *r.SafeWALSize < 0 {
+			safe = "exceeded by " + humanize.IBytes(uint64(-*r.SafeWALSize))
+		} else if r.SafeWALSize != nil {
+			safe = humanize.IBytes(uint64(*r.SafeWALSize))
+		} else if getSettingInt(result, "max_slot_wal_keep_size") == -1 {
+			safe = "unlimited"
+		}
+		vals = append(vals, r.WALStatus, safe)
+	}
+	return
+}
+
+// getWALDiskFree returns the free space on the disk holding the data
+// directory (and hence pg_wal), if known.
+func getWALDiskFree(result *pgmetrics.Model) (int64, bool) {
+	for _, t := range result.Tablespaces {
+		if t.Name == "pg_default" && t.DiskTotal > 0 && t.DiskTotal >= t.DiskUsed {
+			return t.DiskTotal - t.DiskUsed, true
+		}
+	}
+	return 0, false
+}
+
+// fmtETA formats an estimated time to an event, given the number of bytes to
+// go and the rate in bytes/sec.
+func fmtETA(bytes int64, rate float64) string {
+	d := time.Duration(float64(bytes)/rate) * time.Second
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < 48*time.Hour:
+		return "~" + strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	default:
+		return fmt.Sprintf("~%d days", int(d/(24*time.Hour)))
+	}
+}
+
+// slotProblem is a problem found with a single replication slot.
+type slotProblem struct {
+	slot     *pgmetrics.ReplicationSlot
+	severity string
+	problem  string
+}
+
+func getSlotProblems(result *pgmetrics.Model) (out []slotProblem) {
+	add := func(r *pgmetrics.ReplicationSlot, sev, problem string) {
+		out = append(out, slotProblem{slot: r, severity: sev, problem: problem})
+	}
+	rate := getWALRate(result)
+	free, hasFree := getWALDiskFree(result)
+	for i := range result.ReplicationSlots {
+		r := &result.ReplicationSlots[i]
+		switch r.WALStatus {
+		case "lost":
+			add(r, sevCritical, "required WAL has been removed, slot can no longer be used")
+			continue
+		case "unreserved":
+			if r.SafeWALSize == nil || *r.SafeWALSize >= 0 {
+				add(r, sevCritical, "required WAL will be removed at the next checkpoint")
+			}
+		case "extended":
+			add(r, sevWarning, "retaining WAL beyond max_wal_size")
+		}
+		if r.SafeWALSize != nil && *r.SafeWALSize < 0 {
+			add(r, sevCritical, "slot past max_slot_wal_keep_size, WAL will be removed at next checkpoint")
+		}
+		if r.Active || r.Temporary {
+			continue
+		}
+
+		// inactive slots hold back WAL removal and vacuum indefinitely
+		if r.RetainedWAL > 0 {
+			add(r, sevWarning, "inactive, retaining "+humanize.IBytes(uint64(r.RetainedWAL))+" of WAL")
+		}
+		if r.Xmin > 0 {
+			add(r, sevWarning, fmt.Sprintf("inactive, holding back xmin at %d, preventing vacuum cleanup", r.Xmin))
+		}
+		if r.CatalogXmin > 0 {
+			add(r, sevWarning, fmt.Sprintf("inactive, holding back catalog_xmin at %d, preventing vacuum of system catalogs", r.CatalogXmin))
+		}
+
+		// estimate when the slot will hit max_slot_wal_keep_size or the disk
+		// will fill up, assuming the current average rate of WAL generation
+		if rate <= 0 || len(r.RestartLSN) == 0 {
+			continue
+		}
+		if safe := r.SafeWALSize; safe != nil && *safe < 0 {
+			continue // already past the limit
+		} else if safe != nil && (!hasFree || *safe <= free) {
+			add(r, sevWarning, "will hit max_slot_wal_keep_size and lose required WAL in "+
+				fmtETA(*safe, rate))
+		} else if hasFree {
+			// no limit, or the limit is more than the free space
+			add(r, sevWarning, "disk holding pg_wal will fill up in "+fmtETA(free, rate))
+		}
+	}
+	return
+}
+
+func reportSlotHealth(fd io.Writer, result *pgmetrics.Model) {
+	// retained WAL and wal_status are available only from schema 1.11
+	if !schemaAtLeast(result, 1, 11) {
+		return
+	}
+	problems := getSlotProblems(result)
+	if len(problems) == 0 {
+		return
+	}
+
+	var total int64
+	var inactive int
+	for _, r := range result.ReplicationSlots {
+		total += r.RetainedWAL
+		if !r.Active {
+			inactive++
+		}
+	}
+	rate := "unknown"
+	if v := getWALRate(result); v > 0 {
+		rate = humanize.IBytes(uint64(v)) + "/s (average)"
+	}
+	free := "unknown"
+	if v, ok := getWALDiskFree(result); ok {
+		free = humanize.IBytes(uint64(v))
+	}
+	fmt.Fprintf(fd, `
+Replication Slot Health:
+    Inactive Slots:      %d of %d
+    WAL Retained:        %s
+    WAL Generation Rate: %s
+    Disk Free for WAL:   %s
+`,
+		inactive, len(result.ReplicationSlots),
+		humanize.IBytes(uint64(total)),
+		rate,
+		free,
+	)
+
+	var tw tableWriter
+	tw.add("Slot", "Type", "Severity", "Problem")
+	for _, p := range problems {
+		tw.add(p.slot.SlotName, p.slot.SlotType, p.severity, p.problem)
+	}
+	tw.write(fd, "    ")
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// protocol versions that are deprecated (RFC 8996) or broken
+var oldTLSVersions = []string{"SSLv2", "SSLv3", "TLSv1", "TLSv1.1"}
+
+func reportSSL(fd io.Writer, result *pgmetrics.Model) {
+	// ssl info is available only from schema 1.11, and pg v9.5
+	if !schemaAtLeast(result, 1, 11) || getVersion(result) < 90500 {
+		return
+	}
+
+	// only connections over TCP/IP are considered
+	type clientKey struct {
+		user, app, addr string
+	}
+	type clientCount struct {
+		total, plain int
+	}
+	type tlsKey struct {
+		version, cipher string
+		bits            int
+	}
+	var total, plain int
+	clients := make(map[clientKey]*clientCount)
+	tls := make(map[tlsKey]int)
+	for _, be := range result.Backends {
+		if len(be.ClientAddr) == 0 {
+			continue
+		}
+		total++
+		k := clientKey{be.RoleName, be.ApplicationName, be.ClientAddr}
+		cc, ok := clients[k]
+		if !ok {
+			cc = &clientCount{}
+			clients[k] = cc
+		}
+		cc.total++
+		if be.SSL {
+			tls[tlsKey{be.SSLVersion, be.SSLCipher, be.SSLBits}]++
+		} else {
+			plain++
+			cc.plain++
+		}
+	}
+	if total == 0 {
+		return
+	}
+
+	fmt.Fprintf(fd, `
+SSL/TLS Connections:
+    TCP/IP Clients:      %d
+    Unencrypted:         %d (%.1f%%)
+    SSL Setting:         %s
+`,
+		total,
+		plain, 100*float64(plain)/float64(total),
+		getSetting(result, "ssl"),
+	)
+
+	if plain > 0 {
+		keys := make([]clientKey, 0, len(clients))
+		for k, cc := range clients {
+			if cc.plain > 0 {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			ci, cj := clients[keys[i]], clients[keys[j]]
+			if ci.plain != cj.plain {
+				return ci.plain > cj.plain
+			}
+			if keys[i].user != keys[j].user {
+				return keys[i].user < keys[j].user
+			}
+			if keys[i].app != keys[j].app {
+				return keys[i].app < keys[j].app
+			}
+			return keys[i].addr < keys[j].addr
+		})
+		fmt.Fprint(fd, "    Unencrypted Connections:\n")
+		var tw tableWriter
+		tw.add("User", "Application", "Client Address", "Unencrypted", "Total")
+		for _, k := range keys {
+			cc := clients[k]
+			tw.add(k.user, k.app, k.addr, cc.plain, cc.total)
+		}
+		tw.write(fd, "      ")
+	}
+
+	if len(tls) > 0 {
+		keys := make([]tlsKey, 0, len(tls))
+		for k := range tls {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].version != keys[j].version {
+				return keys[i].version < keys[j].version
+			}
+			return keys[i].cipher < keys[j].cipher
+		})
+		fmt.Fprint(fd, "    Encrypted Connections:\n")
+		var tw tableWriter
+		tw.add("Version", "Cipher", "Bits", "Connections", "Note")
+		for _, k := range keys {
+			var note string
+			if arrayHas(oldTLSVersions, k.version) {
+				note = "outdated protocol version"
+			}
+			tw.add(k.version, k.cipher, k.bits, tls[k], note)
+		}
+		tw.write(fd, "      ")
+	}
+}
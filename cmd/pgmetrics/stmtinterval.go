@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// If pg_stat_statements has at least this fraction of its max entries, new
+// statements will cause others to be deallocated.
+const stmtFullFraction = 0.95
+
+// isStmtChurning checks if pg_stat_statements entries were deallocated during
+// the interval to make room for new ones.
+func isStmtChurning(si *pgmetrics.StatementsInterval) bool {
+	if si.Dealloc >= 0 {
+		return si.Dealloc > 0
+	}
+	return si.Removed > 0 && si.Max > 0 &&
+		float64(si.Entries2) >= stmtFullFraction*float64(si.Max)
+}
+
+func reportStatementsInterval(fd io.Writer, result *pgmetrics.Model) {
+	si := result.StatementsInterval
+	if si == nil || si.Duration <= 0 {
+		return
+	}
+
+	entries := fmt.Sprintf("%d, then %d", si.Entries1, si.Entries2)
+	if si.Max > 0 {
+		entries += fmt.Sprintf(" (max %d)", si.Max)
+	}
+	dealloc := "not available"
+	if si.Dealloc >= 0 {
+		dealloc = fmt.Sprintf("%d", si.Dealloc)
+	}
+	fmt.Fprintf(fd, `
+Statement Workload Over Interval:
+    Interval:            %v, from %s
+    Statements Executed: %d
+    Calls:               %.1f/s
+    Execution Time:      %.1f ms/s
+    Entries:             %s
+    Added, Removed:      %d, %d
+    Deallocations:       %s
+`,
+		(time.Duration(si.Duration * float64(time.Second))).Round(time.Millisecond),
+		fmtTime(si.Start),
+		si.Executed,
+		float64(si.Totals.Calls)/si.Duration,
+		si.Totals.TotalTime/si.Duration,
+		entries,
+		si.Added, si.Removed,
+		dealloc,
+	)
+	if isStmtChurning(si) {
+		fmt.Fprint(fd, `    Warning:             pg_stat_statements entries are being deallocated to
+                         make room for new ones, statistics of less frequent
+                         statements are being lost; consider increasing
+                         pg_stat_statements.max
+`)
+	}
+
+	for _, d := range workloadDims {
+		if d.rate == nil {
+			continue
+		}
+		total := d.value(&si.Totals)
+		if total <= 0 {
+			continue
+		}
+		stmts := make([]*pgmetrics.Statement, 0, len(si.Statements))
+		for i := range si.Statements {
+			if d.value(&si.Statements[i]) > 0 {
+				stmts = append(stmts, &si.Statements[i])
+			}
+		}
+		sort.SliceStable(stmts, func(i, j int) bool {
+			return d.value(stmts[i]) > d.value(stmts[j])
+		})
+		if len(stmts) > workloadTopN {
+			stmts = stmts[:workloadTopN]
+		}
+		fmt.Fprintf(fd, "    Top by %s:\n", d.title)
+		var tw tableWriter
+		tw.add(d.title, "% of Total", "User", "Database", "Query")
+		for _, s := range stmts {
+			v := d.value(s)
+			tw.add(d.rate(v/si.Duration),
+				fmt.Sprintf("%.1f", 100*v/total),
+				s.UserName, s.DBName, prepQ(s.Query))
+		}
+		tw.write(fd, "      ")
+	}
+}
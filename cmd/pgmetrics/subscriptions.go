@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+func fmtSubTableState(state string) string {
+	switch state {
+	case "i":
+		return "initializing"
+	case "d":
+		return "copying data"
+	case "f":
+		return "finished copy"
+	case "s":
+		return "synchronized"
+	case "r":
+		return "ready"
+	}
+	return state
+}
+
+func fmtErrorCount(n, since int64) string {
+	if n == 0 || since == 0 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%d (since %s)", n, fmtTime(since))
+}
+
+// reportSubscriptionDetail reports the error counts and the tables that are
+// not yet ready in a subscription. It continues the subscription block
+// printed by reportDatabases.
+func reportSubscriptionDetail(fd io.Writer, result *pgmetrics.Model, s *pgmetrics.Subscription) {
+	// available only from schema 1.11
+	if !schemaAtLeast(result, 1, 11) {
+		return
+	}
+	if getVersion(result) >= 150000 {
+		fmt.Fprintf(fd, `        Apply Errors:      %s
+        Sync Errors:       %s
+`,
+			fmtErrorCount(s.ApplyErrorCount, s.StatsReset),
+			fmtErrorCount(s.SyncErrorCount, s.StatsReset),
+		)
+	}
+
+	var tw tableWriter
+	tw.add("Table", "State", "Sync Worker?", "Note")
+	for _, t := range s.Tables {
+		if t.State == "r" {
+			continue
+		}
+		var note string
+		if !t.HasWorker && s.Enabled {
+			note = "no sync worker running"
+			if s.SyncErrorCount > 0 {
+				note += ", sync errors seen"
+			}
+		}
+		tw.add(t.SchemaName+"."+t.Name, fmtSubTableState(t.State),
+			fmtYesNo(t.HasWorker), note)
+	}
+	fmt.Fprintf(fd, "        Tables Not Ready:  %d\n", len(tw.data)-1)
+	if len(tw.data) > 1 {
+		tw.write(fd, "          ")
+	}
+}
+
+// getTablePublications returns the names of the publications that include
+// the table.
+func getTablePublications(result *pgmetrics.Model, t *pgmetrics.Table) (out []string) {
+	name := t.SchemaName + "." + t.Name
+	for _, p := range filterPublicationsByDB(result, t.DBName) {
+		if p.AllTables || arrayHas(p.Tables, name) {
+			out = append(out, p.Name)
+		}
+	}
+	return
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// getSearchPath returns the schemas in the search_path setting, for the
+// given user.
+func getSearchPath(result *pgmetrics.Model, user string) (out []string) {
+	sp := getSetting(result, "search_path")
+	if len(sp) == 0 {
+		sp = `"$user", public`
+	}
+	for _, s := range strings.Split(sp, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+			s = strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+		}
+		if s == "$user" {
+			s = user
+		}
+		if len(s) > 0 {
+			out = append(out, s)
+		}
+	}
+	return
+}
+
+// resolveTables returns the tables in the model that are referenced by the
+// query of the statement, as "schema.table". Unqualified names are looked up
+// in the schemas of the search path, like Postgres does.
+func resolveTables(result *pgmetrics.Model, s *pgmetrics.Statement,
+	known map[string]bool) (out []string) {
+	var path []string
+	for _, ref := range pgmetrics.TableRefs(s.Query) {
+		if len(ref.Schema) > 0 {
+			if name := ref.Schema + "." + ref.Name; known[s.DBName+"."+name] {
+				out = append(out, name)
+			}
+			continue
+		}
+		if path == nil {
+			path = append([]string{"pg_catalog"}, getSearchPath(result, s.UserName)...)
+		}
+		for _, schema := range path {
+			if name := schema + "." + ref.Name; known[s.DBName+"."+name] {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	return
+}
+
+// setStatementTables fills in the tables referenced by each statement, for
+// the databases whose tables were collected.
+func setStatementTables(result *pgmetrics.Model) {
+	if len(result.Tables) == 0 {
+		return
+	}
+	known := make(map[string]bool)
+	for _, t := range result.Tables {
+		known[t.DBName+"."+t.SchemaName+"."+t.Name] = true
+	}
+	for i := range result.Statements {
+		if s := &result.Statements[i]; len(s.Tables) == 0 {
+			s.Tables = resolveTables(result, s, known)
+		}
+	}
+	if si := result.StatementsInterval; si != nil {
+		for i := range si.Statements {
+			if s := &si.Statements[i]; len(s.Tables) == 0 {
+				s.Tables = resolveTables(result, s, known)
+			}
+		}
+	}
+}
+
+// tableWorkload is the sum of the statements that reference a table.
+type tableWorkload struct {
+	count     int
+	calls     int64
+	totalTime float64
+	blksRead  int64
+	ioTime    float64
+}
+
+// getTableWorkloads rolls up the statements by the tables they reference,
+// keyed by "db.schema.table". A statement that references more than one
+// table is counted against each of them.
+func getTableWorkloads(result *pgmetrics.Model) (m map[string]*tableWorkload, totalTime float64) {
+	m = make(map[string]*tableWorkload)
+	for _, s := range result.Statements {
+		totalTime += s.TotalTime
+		for _, t := range s.Tables {
+			key := s.DBName + "." + t
+			w, ok := m[key]
+			if !ok {
+				w = &tableWorkload{}
+				m[key] = w
+			}
+			w.count++
+			w.calls += s.Calls
+			w.totalTime += s.TotalTime
+			w.blksRead += s.SharedBlksRead
+			w.ioTime += s.BlkReadTime + s.BlkWriteTime
+		}
+	}
+	return
+}
+
+// reportTableWorkload writes the time and I/O of the statements that
+// reference the table, as part of the table's section in reportTables.
+func reportTableWorkload(fd io.Writer, w *tableWorkload, totalTime float64) {
+	if w == nil {
+		return
+	}
+	var pct float64
+	if totalTime > 0 {
+		pct = 100 * w.totalTime / totalTime
+	}
+	fmt.Fprintf(fd, `
+    Statement Time:      %s (%.1f%%) in %d statements, %d calls
+    Statement I/O:       %d blocks read, %s I/O time`,
+		prepmsec(w.totalTime), pct, w.count, w.calls,
+		w.blksRead, prepmsec(w.ioTime))
+}
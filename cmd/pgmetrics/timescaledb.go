@@ -0,0 +1,152 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+func fmtCompression(before, after int64) string {
+	if after <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1fx", float64(before)/float64(after))
+}
+
+func fmtTimeRange(start, end int64) string {
+	if start == 0 || end == 0 {
+		return ""
+	}
+	const layout = "2 Jan 2006 15:04"
+	return time.Unix(start, 0).Format(layout) + " - " +
+		time.Unix(end, 0).Format(layout)
+}
+
+func fmtJobStatus(j *pgmetrics.TimescaleJob) string {
+	s := j.LastRunStatus
+	if !j.Scheduled {
+		if len(s) > 0 {
+			s += ", "
+		}
+		s += "paused"
+	}
+	return s
+}
+
+func reportTimescaleDB(fd io.Writer, result *pgmetrics.Model) {
+	for _, db := range result.Metadata.CollectedDBs {
+		if ts, ok := result.TimescaleDB[db]; ok && ts != nil {
+			reportTimescaleDBOne(fd, db, ts)
+		}
+	}
+}
+
+func reportTimescaleDBOne(fd io.Writer, db string, ts *pgmetrics.TimescaleDB) {
+	var chunks, compressed, nCompressed int
+	var size, before, after int64
+	for _, h := range ts.Hypertables {
+		chunks += h.NumChunks
+		compressed += h.CompressedChunks
+		if h.CompressionEnabled {
+			nCompressed++
+		}
+		if h.Size < 0 || size < 0 {
+			size = -1
+		} else {
+			size += h.Size
+		}
+		before += h.BeforeCompression
+		after += h.AfterCompression
+	}
+
+	fmt.Fprintf(fd, `
+TimescaleDB in "%s":
+    Version:             %s
+    Hypertables:         %d, with %d chunks`,
+		db, ts.Version, len(ts.Hypertables), chunks)
+	if size >= 0 {
+		fmt.Fprintf(fd, ", %s", humanize.IBytes(uint64(size)))
+	}
+	fmt.Fprintf(fd, `
+    Compression:         enabled on %d, %d of %d chunks compressed`,
+		nCompressed, compressed, chunks)
+	if after > 0 {
+		fmt.Fprintf(fd, ", %s to %s (%s)", humanize.IBytes(uint64(before)),
+			humanize.IBytes(uint64(after)), fmtCompression(before, after))
+	}
+	fmt.Fprintln(fd)
+
+	if len(ts.Hypertables) > 0 {
+		var tw tableWriter
+		tw.add("Hypertable", "Chunks", "Compressed", "Size", "Before", "After",
+			"Ratio", "Time Range")
+		for _, h := range ts.Hypertables {
+			var sz, b, a string
+			if h.Size >= 0 {
+				sz = humanize.IBytes(uint64(h.Size))
+			}
+			if h.AfterCompression > 0 {
+				b = humanize.IBytes(uint64(h.BeforeCompression))
+				a = humanize.IBytes(uint64(h.AfterCompression))
+			}
+			var comp string
+			if h.CompressionEnabled {
+				comp = fmt.Sprintf("%d", h.CompressedChunks)
+			}
+			tw.add(h.SchemaName+"."+h.Name, h.NumChunks, comp, sz, b, a,
+				fmtCompression(h.BeforeCompression, h.AfterCompression),
+				fmtTimeRange(h.RangeStart, h.RangeEnd))
+		}
+		fmt.Fprintln(fd, "    Chunks and Compression:")
+		tw.write(fd, "      ")
+	}
+
+	if len(ts.Jobs) > 0 {
+		var tw tableWriter
+		tw.add("ID", "Job", "Hypertable", "Schedule", "Last Run", "Status",
+			"Next Start", "Runs", "Failures")
+		for i := range ts.Jobs {
+			j := &ts.Jobs[i]
+			var ht string
+			if len(j.HypertableName) > 0 {
+				ht = j.HypertableSchema + "." + j.HypertableName
+			}
+			tw.add(j.ID, j.ProcName, ht,
+				time.Duration(j.ScheduleInterval)*time.Second,
+				fmtSince(j.LastRunStarted), fmtJobStatus(j),
+				fmtTime(j.NextStart), j.TotalRuns, j.TotalFailures)
+		}
+		fmt.Fprintln(fd, "    Jobs:")
+		tw.write(fd, "      ")
+	}
+
+	if len(ts.JobErrors) > 0 {
+		var tw tableWriter
+		tw.add("Job", "Procedure", "Finished", "Error Code", "Message")
+		for _, e := range ts.JobErrors {
+			tw.add(e.JobID, e.ProcName, fmtTimeAndSince(e.Finish), e.SQLErrCode,
+				e.Message)
+		}
+		fmt.Fprintln(fd, "    Recent Job Errors:")
+		tw.write(fd, "      ")
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// topology is the replication topology, arranged as a forest of trees.
+type topology struct {
+	nodes    []*pgmetrics.ReplicationNode
+	roots    []*pgmetrics.ReplicationNode
+	children map[*pgmetrics.ReplicationNode][]*pgmetrics.ReplicationNode
+	parent   map[*pgmetrics.ReplicationNode]*pgmetrics.ReplicationNode
+}
+
+func getTopology(result *pgmetrics.Model) *topology {
+	t := &topology{
+		children: make(map[*pgmetrics.ReplicationNode][]*pgmetrics.ReplicationNode),
+		parent:   make(map[*pgmetrics.ReplicationNode]*pgmetrics.ReplicationNode),
+	}
+	byAddr := make(map[string]*pgmetrics.ReplicationNode)
+	for i := range result.ReplicationTopology {
+		n := &result.ReplicationTopology[i]
+		t.nodes = append(t.nodes, n)
+		byAddr[n.Address] = n
+	}
+	for _, n := range t.nodes {
+		if p, ok := byAddr[n.Upstream]; ok && p != n && !t.isAncestor(n, p) {
+			t.parent[n] = p
+			t.children[p] = append(t.children[p], n)
+		} else {
+			t.roots = append(t.roots, n)
+		}
+	}
+	return t
+}
+
+// isAncestor checks if a is an ancestor of n, to avoid cycles.
+func (t *topology) isAncestor(a, n *pgmetrics.ReplicationNode) bool {
+	for p := t.parent[n]; p != nil; p = t.parent[p] {
+		if p == a {
+			return true
+		}
+	}
+	return n == a
+}
+
+// hopLag returns the replication lag between a node and its upstream, in
+// bytes and seconds. The time lag is known only if the upstream is v10+.
+func hopLag(parent, child *pgmetrics.ReplicationNode) (bytes int64, secs int, hasBytes, hasSecs bool) {
+	if d, ok := lsnDiff(parent.LSN, child.LSN); ok {
+		if d < 0 {
+			d = 0 // collected at slightly different times
+		}
+		bytes, hasBytes = d, true
+	}
+	host, _, err := net.SplitHostPort(child.Address)
+	if err != nil {
+		host = child.Address
+	}
+	for _, r := range parent.ReplicationOutgoing {
+		addr := r.ClientAddr
+		if pos := strings.IndexByte(addr, '/'); pos > 0 {
+			addr = addr[:pos]
+		}
+		if addr == host || (len(child.ServerAddr) > 0 && addr == child.ServerAddr) {
+			// replay_lag is only available from v10
+			secs, hasSecs = r.ReplayLag, !strings.HasPrefix(parent.ServerVersion, "9.")
+			break
+		}
+	}
+	return
+}
+
+func fmtNodeLabel(n *pgmetrics.ReplicationNode) string {
+	if len(n.Error) > 0 {
+		return n.Address + " (error: " + n.Error + ")"
+	}
+	var parts []string
+	if len(n.ClusterName) > 0 {
+		parts = append(parts, n.ClusterName)
+	}
+	if n.IsInRecovery {
+		parts = append(parts, "standby")
+	} else {
+		parts = append(parts, "primary")
+	}
+	if len(n.ServerVersion) > 0 {
+		parts = append(parts, "v"+n.ServerVersion)
+	}
+	if len(n.LSN) > 0 {
+		parts = append(parts, "lsn "+n.LSN)
+	}
+	return n.Address + " (" + strings.Join(parts, ", ") + ")"
+}
+
+func fmtHopLag(t *topology, n *pgmetrics.ReplicationNode) string {
+	p := t.parent[n]
+	if p == nil || len(n.Error) > 0 || len(p.Error) > 0 {
+		return ""
+	}
+	bytes, secs, hasBytes, hasSecs := hopLag(p, n)
+	var parts []string
+	if hasBytes {
+		parts = append(parts, humanize.IBytes(uint64(bytes)))
+	}
+	if hasSecs {
+		parts = append(parts, (time.Duration(secs) * time.Second).String())
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "lag " + strings.Join(parts, ", ")
+}
+
+func reportTopology(fd io.Writer, result *pgmetrics.Model) {
+	if len(result.ReplicationTopology) == 0 {
+		return
+	}
+	t := getTopology(result)
+	fmt.Fprintf(fd, `
+Replication Topology:
+    Nodes:               %d
+`, len(t.nodes))
+
+	var walk func(n *pgmetrics.ReplicationNode, prefix, branch string)
+	walk = func(n *pgmetrics.ReplicationNode, prefix, branch string) {
+		line := prefix + branch + fmtNodeLabel(n)
+		if lag := fmtHopLag(t, n); len(lag) > 0 {
+			line += " " + lag
+		}
+		fmt.Fprintln(fd, line)
+		kids := t.children[n]
+		switch branch {
+		case "├── ":
+			prefix += "│   "
+		case "└── ":
+			prefix += "    "
+		}
+		for i, k := range kids {
+			if i == len(kids)-1 {
+				walk(k, prefix, "└── ")
+			} else {
+				walk(k, prefix, "├── ")
+			}
+		}
+	}
+	for _, r := range t.roots {
+		if len(r.Upstream) > 0 {
+			fmt.Fprintf(fd, "    %s (not reachable)\n", r.Upstream)
+			walk(r, "    ", "└── ")
+		} else {
+			walk(r, "    ", "")
+		}
+	}
+}
+
+// dotQuote quotes a string for use as a Graphviz DOT identifier.
+func dotQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+func writeTopologyDOT(fd io.Writer, result *pgmetrics.Model) {
+	t := getTopology(result)
+	fmt.Fprintln(fd, "digraph replication {")
+	fmt.Fprintln(fd, "  rankdir=LR;")
+	fmt.Fprintln(fd, "  node [shape=box];")
+	for _, n := range t.nodes {
+		attrs := "label=" + dotQuote(strings.Replace(fmtNodeLabel(n), " (", "\n(", 1))
+		if len(n.Error) > 0 {
+			attrs += ", color=red"
+		} else if !n.IsInRecovery {
+			attrs += ", style=bold"
+		}
+		fmt.Fprintf(fd, "  %s [%s];\n", dotQuote(n.Address), attrs)
+	}
+	for _, n := range t.nodes {
+		if p := t.parent[n]; p != nil {
+			fmt.Fprintf(fd, "  %s -> %s [label=%s];\n", dotQuote(p.Address),
+				dotQuote(n.Address), dotQuote(strings.TrimPrefix(fmtHopLag(t, n), "lag ")))
+		} else if len(n.Upstream) > 0 {
+			fmt.Fprintf(fd, "  %s [style=dashed];\n", dotQuote(n.Upstream))
+			fmt.Fprintf(fd, "  %s -> %s [style=dashed];\n", dotQuote(n.Upstream),
+				dotQuote(n.Address))
+		}
+	}
+	fmt.Fprintln(fd, "}")
+}
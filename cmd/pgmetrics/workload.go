@@ -0,0 +1,262 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Show only these many rows in each of the workload tables.
+const workloadTopN = 10
+
+// Statements that have touched fewer shared blocks than this are not ranked
+// by cache hit ratio.
+const workloadMinBlocks = 1000
+
+// workloadDim is a dimension along which statements are ranked, in both the
+// workload and the interval workload reports.
+type workloadDim struct {
+	title  string
+	value  func(s *pgmetrics.Statement) float64
+	format func(v float64) string
+	// format a per-second value; only dimensions that have this are shown in
+	// the interval workload report
+	rate func(v float64) string
+	// the value can be summed across statements, and hence has a share
+	additive bool
+	// rank from the lowest value upwards
+	ascending bool
+	// include only statements for which this returns true, if set
+	filter func(s *pgmetrics.Statement) bool
+	// available only from this version of postgres, if set
+	minVersion int
+}
+
+func fmtCount(v float64) string {
+	return fmt.Sprintf("%.0f", v)
+}
+
+// fmtMeanTime formats a time in milliseconds, keeping sub-millisecond
+// precision for small values.
+func fmtMeanTime(ms float64) string {
+	if ms < 1 {
+		return fmtMicros(int64(ms * 1000))
+	}
+	return prepmsec(ms)
+}
+
+func stmtMeanTime(s *pgmetrics.Statement) float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.TotalTime / float64(s.Calls)
+}
+
+func stmtHitRatio(s *pgmetrics.Statement) float64 {
+	total := s.SharedBlksHit + s.SharedBlksRead
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(s.SharedBlksHit) / float64(total)
+}
+
+func fmtPerSec(v float64) string {
+	return fmt.Sprintf("%.1f/s", v)
+}
+
+func fmtMsPerSec(v float64) string {
+	return fmt.Sprintf("%.1f ms/s", v)
+}
+
+var workloadDims = []workloadDim{
+	{title: "Total Time", additive: true, format: prepmsec, rate: fmtMsPerSec,
+		value: func(s *pgmetrics.Statement) float64 { return s.TotalTime }},
+	{title: "Calls", additive: true, format: fmtCount, rate: fmtPerSec,
+		value: func(s *pgmetrics.Statement) float64 { return float64(s.Calls) }},
+	{title: "Rows", additive: true, format: fmtCount, rate: fmtPerSec,
+		value: func(s *pgmetrics.Statement) float64 { return float64(s.Rows) }},
+	{title: "Mean Time", format: fmtMeanTime, value: stmtMeanTime},
+	{title: "Planning Time", additive: true, format: prepmsec, rate: fmtMsPerSec,
+		minVersion: 130000,
+		value:      func(s *pgmetrics.Statement) float64 { return s.TotalPlanTime }},
+	{title: "I/O Time", additive: true, format: prepmsec, rate: fmtMsPerSec,
+		value: func(s *pgmetrics.Statement) float64 { return s.BlkReadTime + s.BlkWriteTime }},
+	{title: "Shared Blocks Read", additive: true, format: fmtCount, rate: fmtPerSec,
+		value: func(s *pgmetrics.Statement) float64 { return float64(s.SharedBlksRead) }},
+	{title: "Temp Blocks", additive: true, format: fmtCount, rate: fmtPerSec,
+		value: func(s *pgmetrics.Statement) float64 { return float64(s.TempBlksRead + s.TempBlksWritten) }},
+	{title: "WAL Bytes", additive: true, minVersion: 130000,
+		format: func(v float64) string { return humanize.IBytes(uint64(v)) },
+		rate:   func(v float64) string { return humanize.IBytes(uint64(v)) + "/s" },
+		value:  func(s *pgmetrics.Statement) float64 { return float64(s.WALBytes) }},
+	{title: "Cache Hit Ratio", ascending: true, value: stmtHitRatio,
+		format: func(v float64) string { return fmt.Sprintf("%.1f%%", v) },
+		filter: func(s *pgmetrics.Statement) bool {
+			return s.SharedBlksHit+s.SharedBlksRead >= workloadMinBlocks
+		}},
+}
+
+// stmtRollup is the sum of the statements executed by a user or in a
+// database.
+type stmtRollup struct {
+	key       string
+	count     int
+	calls     int64
+	totalTime float64
+	blksRead  int64
+	tempWrite int64
+	walBytes  int64
+}
+
+func rollupStatements(stmts []pgmetrics.Statement,
+	keyfn func(s *pgmetrics.Statement) string) (out []*stmtRollup) {
+	m := make(map[string]*stmtRollup)
+	for i := range stmts {
+		s := &stmts[i]
+		k := keyfn(s)
+		r, ok := m[k]
+		if !ok {
+			r = &stmtRollup{key: k}
+			m[k] = r
+			out = append(out, r)
+		}
+		r.count++
+		r.calls += s.Calls
+		r.totalTime += s.TotalTime
+		r.blksRead += s.SharedBlksRead
+		r.tempWrite += s.TempBlksWritten
+		r.walBytes += s.WALBytes
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].totalTime > out[j].totalTime
+	})
+	return
+}
+
+func reportWorkload(fd io.Writer, result *pgmetrics.Model) {
+	if len(result.Statements) == 0 {
+		return
+	}
+	version := getVersion(result)
+
+	var calls int64
+	var totalTime float64
+	for _, s := range result.Statements {
+		calls += s.Calls
+		totalTime += s.TotalTime
+	}
+	fmt.Fprintf(fd, `
+Workload:
+    Statements:          %d (top by total time)
+    Calls:               %d
+    Total Time:          %s
+`,
+		len(result.Statements), calls, prepmsec(totalTime))
+
+	for _, d := range workloadDims {
+		if d.minVersion > 0 && version < d.minVersion {
+			continue
+		}
+		var total float64
+		stmts := make([]*pgmetrics.Statement, 0, len(result.Statements))
+		for i := range result.Statements {
+			s := &result.Statements[i]
+			v := d.value(s)
+			total += v
+			if d.filter != nil && !d.filter(s) {
+				continue
+			}
+			if v > 0 || d.ascending {
+				stmts = append(stmts, s)
+			}
+		}
+		if len(stmts) == 0 || (d.additive && total <= 0) {
+			continue
+		}
+		sort.SliceStable(stmts, func(i, j int) bool {
+			if d.ascending {
+				return d.value(stmts[i]) < d.value(stmts[j])
+			}
+			return d.value(stmts[i]) > d.value(stmts[j])
+		})
+		if len(stmts) > workloadTopN {
+			stmts = stmts[:workloadTopN]
+		}
+
+		fmt.Fprintf(fd, "    Top by %s:\n", d.title)
+		var tw tableWriter
+		// for non-additive values, show the share of time instead
+		share := "% of Total"
+		if !d.additive {
+			share = "% of Time"
+		}
+		// no separate calls column when ranking by calls
+		withCalls := d.title != "Calls"
+		cols := []interface{}{d.title, share}
+		if withCalls {
+			cols = append(cols, "Calls")
+		}
+		tw.add(append(cols, "User", "Database", "Query")...)
+		for _, s := range stmts {
+			var pct float64
+			if d.additive {
+				pct = 100 * d.value(s) / total
+			} else if totalTime > 0 {
+				pct = 100 * s.TotalTime / totalTime
+			}
+			vals := []interface{}{d.format(d.value(s)), fmt.Sprintf("%.1f", pct)}
+			if withCalls {
+				vals = append(vals, s.Calls)
+			}
+			tw.add(append(vals, s.UserName, s.DBName, prepQ(s.Query))...)
+		}
+		tw.write(fd, "      ")
+	}
+
+	writeRollup := func(title string, rollups []*stmtRollup) {
+		fmt.Fprintf(fd, "    By %s:\n", title)
+		var tw tableWriter
+		cols := []interface{}{title, "Statements", "Calls", "Total Time",
+			"% of Time", "Shared Blocks Read", "Temp Blocks Written"}
+		if version >= 130000 {
+			cols = append(cols, "WAL Bytes")
+		}
+		tw.add(cols...)
+		for _, r := range rollups {
+			var pct float64
+			if totalTime > 0 {
+				pct = 100 * r.totalTime / totalTime
+			}
+			vals := []interface{}{r.key, r.count, r.calls, prepmsec(r.totalTime),
+				fmt.Sprintf("%.1f", pct), r.blksRead, r.tempWrite}
+			if version >= 130000 {
+				vals = append(vals, humanize.IBytes(uint64(r.walBytes)))
+			}
+			tw.add(vals...)
+		}
+		tw.write(fd, "      ")
+	}
+	writeRollup("User", rollupStatements(result.Statements,
+		func(s *pgmetrics.Statement) string { return s.UserName }))
+	writeRollup("Database", rollupStatements(result.Statements,
+		func(s *pgmetrics.Statement) string { return s.DBName }))
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// sampleActivity queries pg_stat_activity and pg_locks every interval, for
+// the given duration, and aggregates the non-idle backends seen.
+func (c *collector) sampleActivity(duration, interval time.Duration) {
+	q := `SELECT COALESCE(a.datname, ''), COALESCE(a.usename, ''),
+			COALESCE(a.application_name, ''), COALESCE(a.state, ''),
+			COALESCE(a.wait_event_type, ''), COALESCE(a.wait_event, ''),
+			LEFT(COALESCE(a.query, ''), $1),
+			COALESCE(l.locktype, ''), COALESCE(l.mode, '')
+		  FROM pg_stat_activity a
+			LEFT JOIN (SELECT DISTINCT ON (pid) pid, locktype, mode
+						FROM pg_locks WHERE NOT granted ORDER BY pid) l
+			ON a.pid = l.pid
+		  WHERE a.pid <> pg_backend_pid() AND a.state <> 'idle'`
+	if c.version < 90600 { // wait_event_type, wait_event only in v9.6+
+		q = strings.Replace(q, "COALESCE(a.wait_event_type, '')",
+			"CASE WHEN a.waiting THEN 'Lock' ELSE '' END", 1)
+		q = strings.Replace(q, "COALESCE(a.wait_event, '')", "''", 1)
+	}
+
+	counts := make(map[pgmetrics.ActivitySampleItem]int)
+	samples := &pgmetrics.ActivitySamples{
+		Start:    time.Now().Unix(),
+		Interval: interval.Seconds(),
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for end := time.Now().Add(duration); ; {
+		if !c.sampleActivityOnce(q, counts) {
+			break
+		}
+		samples.Samples++
+		samples.End = time.Now().Unix()
+		if !time.Now().Add(interval).Before(end) {
+			break
+		}
+		<-tick.C
+	}
+
+	if samples.Samples == 0 {
+		return
+	}
+	for item, n := range counts {
+		item.Count = n
+		samples.Items = append(samples.Items, item)
+	}
+	sort.Slice(samples.Items, func(i, j int) bool {
+		return samples.Items[i].Count > samples.Items[j].Count
+	})
+	c.result.ActivitySamples = samples
+}
+
+// sampleActivityOnce runs the sampling query and adds the rows to counts. It
+// returns false if the query failed.
+func (c *collector) sampleActivityOnce(q string, counts map[pgmetrics.ActivitySampleItem]int) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, q, c.sqlLength)
+	if err != nil {
+		log.Printf("warning: pg_stat_activity sampling query failed: %v", err)
+		return false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item pgmetrics.ActivitySampleItem
+		if err := rows.Scan(&item.DBName, &item.RoleName, &item.ApplicationName,
+			&item.State, &item.WaitEventType, &item.WaitEvent, &item.Query,
+			&item.LockType, &item.LockMode); err != nil {
+			log.Fatalf("pg_stat_activity sampling query failed: %v", err)
+		}
+		counts[item]++
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_activity sampling query failed: %v", err)
+	}
+	return true
+}
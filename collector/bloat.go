@@ -0,0 +1,232 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Relations smaller than this are left with the estimated bloat.
+const exactBloatMinSize = 10 * 1024 * 1024
+
+var errBloatBudget = errors.New("time budget exhausted")
+
+// bloatTarget is a table or index whose bloat is to be measured.
+type bloatTarget struct {
+	table *pgmetrics.Table
+	index *pgmetrics.Index
+	size  int64
+}
+
+// getFillFactor returns the fillfactor from the storage options, or def if
+// it is not set.
+func getFillFactor(relOptions []string, def int) int {
+	for _, o := range relOptions {
+		if strings.HasPrefix(o, "fillfactor=") {
+			if ff, err := strconv.Atoi(o[len("fillfactor="):]); err == nil && ff > 0 {
+				return ff
+			}
+		}
+	}
+	return def
+}
+
+// getBtreeBloat estimates the bloat of btree indexes from the widths of only
+// the columns in the index, replacing the rougher estimate from getBloat.
+func (c *collector) getBtreeBloat(currdb string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, sqlBtreeBloat)
+	if err != nil {
+		log.Printf("warning: btree bloat query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schemaname, indexname string
+		var bloat int64
+		var isNA bool
+		if err := rows.Scan(&schemaname, &indexname, &bloat, &isNA); err != nil {
+			log.Fatalf("btree bloat query failed: %v", err)
+		}
+		// estimates for indexes on "name" columns are unreliable
+		if isNA {
+			continue
+		}
+		if i := c.result.IndexByName(currdb, schemaname, indexname); i != nil {
+			i.Bloat = bloat
+			i.BloatMethod = pgmetrics.BloatMethodBtreeEstimate
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("btree bloat query failed: %v", err)
+	}
+}
+
+// getExactBloat measures the bloat of the larger tables and btree indexes in
+// the current database using the pgstattuple extension, replacing the
+// estimates. Relations are measured largest first, until either the time or
+// the total size of the relations measured exceeds the budget.
+func (c *collector) getExactBloat(currdb string, budgetSecs, budgetMB uint) {
+	// check if pgstattuple extension is present in current database
+	found := false
+	for _, e := range c.result.Extensions {
+		if e.Name == "pgstattuple" && e.DBName == currdb {
+			found = true
+			break
+		}
+	}
+	if !found {
+		log.Printf("warning: pgstattuple extension not found in database %q, "+
+			"using estimated bloat", currdb)
+		return
+	}
+
+	// pick the relations, largest first
+	var targets []bloatTarget
+	if c.version >= 90500 { // pgstattuple_approx is available only in 9.5+
+		for i := range c.result.Tables {
+			t := &c.result.Tables[i]
+			if t.DBName == currdb && (t.RelKind == "r" || t.RelKind == "m") &&
+				t.Size >= exactBloatMinSize {
+				targets = append(targets, bloatTarget{table: t, size: t.Size})
+			}
+		}
+	}
+	for i := range c.result.Indexes {
+		idx := &c.result.Indexes[i]
+		if idx.DBName == currdb && idx.AMName == "btree" && idx.IsValid &&
+			idx.Size >= exactBloatMinSize {
+			targets = append(targets, bloatTarget{index: idx, size: idx.Size})
+		}
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].size > targets[j].size
+	})
+
+	blockSize, _ := strconv.ParseInt(c.setting("block_size"), 10, 64)
+	if blockSize <= 0 {
+		blockSize = 8192
+	}
+	deadline := time.Now().Add(time.Duration(budgetSecs) * time.Second)
+	sizeLeft := int64(budgetMB) * 1024 * 1024
+	var skipped int
+	for _, bt := range targets {
+		if bt.size > sizeLeft {
+			skipped++
+			continue
+		}
+		var err error
+		if bt.table != nil {
+			err = c.measureTableBloat(bt.table, deadline)
+		} else {
+			err = c.measureIndexBloat(bt.index, blockSize, deadline)
+		}
+		if err == errBloatBudget {
+			skipped++
+			continue
+		} else if err != nil {
+			log.Printf("warning: measuring bloat failed: %v", err)
+			continue
+		}
+		sizeLeft -= bt.size
+	}
+	if skipped > 0 {
+		log.Printf("warning: bloat of %d relation(s) in database %q not measured "+
+			"due to time or size budget, using estimates", skipped, currdb)
+	}
+}
+
+// queryWithDeadline runs a single-row query with the oid as the parameter,
+// allowing it to run until the deadline rather than the usual timeout.
+func (c *collector) queryWithDeadline(deadline time.Time, q string, oid int,
+	dest ...interface{}) error {
+	remaining := time.Until(deadline)
+	if remaining < time.Second {
+		return errBloatBudget
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remaining)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := "SET LOCAL statement_timeout = " + strconv.FormatInt(remaining.Milliseconds(), 10)
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, q, oid).Scan(dest...); err != nil {
+		if ctx.Err() != nil || time.Until(deadline) < time.Second {
+			return errBloatBudget // timed out
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *collector) measureTableBloat(t *pgmetrics.Table, deadline time.Time) error {
+	q := `SELECT table_len, dead_tuple_len, approx_free_space
+		  FROM pgstattuple_approx($1::oid::regclass)`
+	var tableLen, deadLen, freeSpace int64
+	if err := c.queryWithDeadline(deadline, q, t.OID, &tableLen, &deadLen,
+		&freeSpace); err != nil {
+		return err
+	}
+	// space left free due to the fillfactor is not bloat
+	reserved := tableLen * int64(100-getFillFactor(t.RelOptions, 100)) / 100
+	t.Bloat = deadLen + freeSpace - reserved
+	if t.Bloat < 0 {
+		t.Bloat = 0
+	}
+	t.BloatMethod = pgmetrics.BloatMethodPgstattupleApprox
+	return nil
+}
+
+func (c *collector) measureIndexBloat(idx *pgmetrics.Index, blockSize int64,
+	deadline time.Time) error {
+	q := `SELECT leaf_pages, empty_pages, deleted_pages, avg_leaf_density
+		  FROM pgstatindex($1::oid::regclass)`
+	var leafPages, emptyPages, deletedPages int64
+	var density float64
+	if err := c.queryWithDeadline(deadline, q, idx.OID, &leafPages, &emptyPages,
+		&deletedPages, &density); err != nil {
+		return err
+	}
+	if math.IsNaN(density) { // no leaf pages
+		density = 0
+	}
+	// leaf pages needed if the leaves were packed to the fillfactor
+	ff := float64(getFillFactor(idx.RelOptions, 90))
+	needed := int64(math.Ceil(float64(leafPages) * math.Min(density/ff, 1)))
+	idx.Bloat = (leafPages - needed + emptyPages + deletedPages) * blockSize
+	idx.BloatMethod = pgmetrics.BloatMethodPgstatindex
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Collect only these many relations, with the most buffers, per database.
+const bufferCacheMaxRels = 500
+
+// The maximum usage count of a buffer (BM_MAX_USAGE_COUNT).
+const bufferMaxUsageCount = 5
+
+// usageCountCols returns SQL expressions for the number of buffers with
+// each usage count, from 0 to bufferMaxUsageCount.
+func usageCountCols() (q string) {
+	for i := 0; i <= bufferMaxUsageCount; i++ {
+		q += fmt.Sprintf(", SUM(CASE WHEN b.usagecount = %d THEN 1 ELSE 0 END)", i)
+	}
+	return
+}
+
+func (c *collector) getBufferCache(currdb string, fillSize bool) {
+	// check if pg_buffercache extension is present in current database
+	found := false
+	for _, e := range c.result.Extensions {
+		if e.Name == "pg_buffercache" && e.DBName == currdb {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	// the summary and the shared catalogs are cluster-wide, get them only once
+	if c.result.BufferCache == nil {
+		c.getBufferCacheSummary()
+		if c.result.BufferCache != nil {
+			c.getBufferCacheRelations("", fillSize)
+		}
+	}
+	if c.result.BufferCache != nil {
+		c.getBufferCacheRelations(currdb, fillSize)
+	}
+}
+
+func (c *collector) getBufferCacheSummary() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT COUNT(*), COUNT(b.relfilenode),
+			COALESCE(SUM(CASE WHEN b.isdirty THEN 1 ELSE 0 END), 0)` +
+		usageCountCols() + `
+		  FROM pg_buffercache b`
+	var bc pgmetrics.BufferCache
+	bc.UsageCounts = make([]int64, bufferMaxUsageCount+1)
+	dest := []interface{}{&bc.Buffers, &bc.Used, &bc.Dirty}
+	for i := range bc.UsageCounts {
+		dest = append(dest, &bc.UsageCounts[i])
+	}
+	if err := c.db.QueryRowContext(ctx, q).Scan(dest...); err != nil {
+		log.Printf("warning: pg_buffercache query failed: %v", err)
+		return
+	}
+	c.result.BufferCache = &bc
+}
+
+// getBufferCacheRelations gets the relations with the most buffers in the
+// current database, or of the shared catalogs if currdb is empty. Only buffers
+// of the main fork are counted, so that they can be compared with its size.
+func (c *collector) getBufferCacheRelations(currdb string, fillSize bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT c.oid, n.nspname, c.relname, c.relkind, COUNT(*),
+			SUM(CASE WHEN b.isdirty THEN 1 ELSE 0 END)` + usageCountCols() + `,
+			CASE WHEN $3 THEN pg_relation_size(c.oid) ELSE -1 END
+		  FROM pg_buffercache b
+			JOIN pg_class c ON b.relfilenode = pg_relation_filenode(c.oid)
+			JOIN pg_namespace n ON c.relnamespace = n.oid
+		  WHERE b.reldatabase = CASE WHEN $2 THEN 0 ELSE
+				(SELECT oid FROM pg_database WHERE datname = current_database()) END
+			AND c.relisshared = $2
+			AND b.relforknumber = 0
+		  GROUP BY c.oid, n.nspname, c.relname, c.relkind
+		  ORDER BY 5 DESC
+		  LIMIT $1`
+	rows, err := c.db.QueryContext(ctx, q, bufferCacheMaxRels, len(currdb) == 0,
+		fillSize)
+	if err != nil {
+		log.Printf("warning: pg_buffercache query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := pgmetrics.BufferCacheRelation{
+			DBName:      currdb,
+			UsageCounts: make([]int64, bufferMaxUsageCount+1),
+		}
+		dest := []interface{}{&r.OID, &r.SchemaName, &r.Name, &r.RelKind,
+			&r.Buffers, &r.Dirty}
+		for i := range r.UsageCounts {
+			dest = append(dest, &r.UsageCounts[i])
+		}
+		dest = append(dest, &r.Size)
+		if err := rows.Scan(dest...); err != nil {
+			log.Fatalf("pg_buffercache query failed: %v", err)
+		}
+		if !c.schemaOK(r.SchemaName) {
+			continue
+		}
+		c.result.BufferCache.Relations = append(c.result.BufferCache.Relations, r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_buffercache query failed: %v", err)
+	}
+}
@@ -72,6 +72,7 @@ type CollectConfig struct {
 	ExclTable       string
 	SQLLength       uint
 	StmtsLimit      uint
+	StmtsInterval   time.Duration
 	Omit            []string
 	OnlyListedDBs   bool
 	LogFile         string
@@ -79,6 +80,14 @@ type CollectConfig struct {
 	LogSpan         uint
 	RDSDBIdentifier string
 	AllDBs          bool
+	FollowReplicas  bool
+	ReplicaHosts    []string // "host" or "host:port"
+	SampleDuration  time.Duration
+	SampleInterval  time.Duration
+	BufferCache     bool
+	ExactBloat      bool
+	ExactBloatSecs  uint // time budget per database, for ExactBloat
+	ExactBloatMB    uint // size budget per database, for ExactBloat
 
 	// connection
 	Host     string
@@ -104,9 +113,11 @@ func DefaultCollectConfig() CollectConfig {
 		//ExclTable: "",
 		//Omit: nil,
 		//OnlyListedDBs: false,
-		SQLLength:  500,
-		StmtsLimit: 100,
-		LogSpan:    5,
+		SQLLength:      500,
+		StmtsLimit:     100,
+		LogSpan:        5,
+		ExactBloatSecs: 60,
+		ExactBloatMB:   10240,
 
 		// ------------------ connection
 		//Password: "",
@@ -158,8 +169,9 @@ func getRegexp(r string) (rx *regexp.Regexp) {
 // Ideally, this should return (*pgmetrics.Model, error). But for now, it does
 // a log.Fatal(). This will be rectified in the future, and
 // backwards-compatibility will be broken when that happens. You've been warned.
-func Collect(o CollectConfig, dbnames []string) *pgmetrics.Model {
-	// form connection string
+// getConnStr forms the connection string for the server specified in the
+// config, without a dbname.
+func getConnStr(o CollectConfig, pgbouncer bool) string {
 	var connstr string
 	if len(o.Host) > 0 {
 		connstr += makeKV("host", o.Host)
@@ -177,10 +189,17 @@ func Collect(o CollectConfig, dbnames []string) *pgmetrics.Model {
 	connstr += makeKV("application_name", "pgmetrics")
 
 	// set timeouts (but not for pgbouncer, it does not like them)
-	if !(len(dbnames) == 1 && dbnames[0] == "pgbouncer") {
+	if !pgbouncer {
 		connstr += makeKV("lock_timeout", strconv.Itoa(int(o.LockTimeoutMillisec)))
 		connstr += makeKV("statement_timeout", strconv.Itoa(int(o.TimeoutSec)*1000))
 	}
+	return connstr
+}
+
+func Collect(o CollectConfig, dbnames []string) *pgmetrics.Model {
+	// form connection string
+	pgbouncer := len(dbnames) == 1 && dbnames[0] == "pgbouncer"
+	connstr := getConnStr(o, pgbouncer)
 
 	// if "all DBs" was specified, collect the names of databases first
 	if o.AllDBs {
@@ -207,6 +226,11 @@ func Collect(o CollectConfig, dbnames []string) *pgmetrics.Model {
 		collectFromRDS(o.RDSDBIdentifier, &c.result)
 	}
 
+	// collect the replication topology if asked to
+	if o.FollowReplicas && !pgbouncer {
+		c.result.ReplicationTopology = collectTopology(o, dbnames)
+	}
+
 	return &c.result
 }
 
@@ -282,25 +306,26 @@ func getDBNames(connstr string, o CollectConfig) (dbnames []string) {
 }
 
 type collector struct {
-	db           *sql.DB
-	result       pgmetrics.Model
-	version      int    // integer form of server version
-	local        bool   // have we connected to the server on the same machine?
-	dataDir      string // the PGDATA dir, valid only if local
-	beenHere     bool
-	timeout      time.Duration
-	rxSchema     *regexp.Regexp
-	rxExclSchema *regexp.Regexp
-	rxTable      *regexp.Regexp
-	rxExclTable  *regexp.Regexp
-	sqlLength    uint
-	stmtsLimit   uint
-	dbnames      []string
-	curlogfile   string
-	csvlog       bool
-	logSpan      uint
-	currLog      logEntry
-	rxPrefix     *regexp.Regexp
+	db            *sql.DB
+	result        pgmetrics.Model
+	version       int    // integer form of server version
+	local         bool   // have we connected to the server on the same machine?
+	dataDir       string // the PGDATA dir, valid only if local
+	beenHere      bool
+	timeout       time.Duration
+	rxSchema      *regexp.Regexp
+	rxExclSchema  *regexp.Regexp
+	rxTable       *regexp.Regexp
+	rxExclTable   *regexp.Regexp
+	sqlLength     uint
+	stmtsLimit    uint
+	stmtsInterval time.Duration
+	dbnames       []string
+	curlogfile    string
+	csvlog        bool
+	logSpan       uint
+	currLog       logEntry
+	rxPrefix      *regexp.Regexp
 }
 
 func (c *collector) collect(db *sql.DB, o CollectConfig) {
@@ -326,6 +351,7 @@ func (c *collector) collectFirst(db *sql.DB, o CollectConfig) {
 	// save limits
 	c.sqlLength = o.SQLLength
 	c.stmtsLimit = o.StmtsLimit
+	c.stmtsInterval = o.StmtsInterval
 	c.logSpan = o.LogSpan
 
 	// current time is the report start time
@@ -400,6 +426,10 @@ func (c *collector) collectCluster(o CollectConfig) {
 		c.getBETypeCountsv10()
 	}
 
+	if c.version >= 90500 {
+		c.getBackendSSL()
+	}
+
 	if c.version >= 90400 {
 		c.getWALArchiver()
 	}
@@ -427,6 +457,17 @@ func (c *collector) collectCluster(o CollectConfig) {
 	if c.version >= 90600 {
 		c.getVacuumProgress()
 	}
+	if c.version >= 120000 {
+		c.getCreateIndexProgress()
+		c.getClusterProgress()
+	}
+	if c.version >= 130000 {
+		c.getAnalyzeProgress()
+		c.getBasebackupProgress()
+	}
+	if c.version >= 140000 {
+		c.getCopyProgress()
+	}
 
 	c.getDatabases(!o.NoSizes, o.OnlyListedDBs, c.dbnames)
 	c.getTablespaces(!o.NoSizes)
@@ -451,9 +492,27 @@ func (c *collector) collectCluster(o CollectConfig) {
 
 	c.getLocks()
 
+	// prepared transactions, added schema 1.11
+	c.getPreparedXacts()
+
+	// config file errors, added schema 1.11
+	if c.version >= 90500 {
+		c.getFileSettingErrors()
+	}
+
+	// hba rules, added schema 1.11
+	if c.version >= 100000 {
+		c.getHBARules()
+	}
+
 	if !arrayHas(o.Omit, "log") && c.local {
 		c.getLogInfo()
 	}
+
+	// sampled activity, added schema 1.11
+	if o.SampleDuration > 0 && o.SampleInterval > 0 {
+		c.sampleActivity(o.SampleDuration, o.SampleInterval)
+	}
 }
 
 // info and stats for the current database
@@ -471,11 +530,17 @@ func (c *collector) collectDatabase(o CollectConfig) {
 	if !arrayHas(o.Omit, "tables") && !arrayHas(o.Omit, "indexes") {
 		c.getIndexes(!o.NoSizes)
 	}
+	// constraints, added schema 1.11
+	if !arrayHas(o.Omit, "tables") {
+		c.getConstraints()
+	}
+	c.getSchemas()
 	if !arrayHas(o.Omit, "sequences") {
 		c.getSequences()
 	}
 	if !arrayHas(o.Omit, "functions") {
 		c.getUserFunctions()
+		c.getSecurityDefiners()
 	}
 	if !arrayHas(o.Omit, "extensions") {
 		c.getExtensions()
@@ -487,6 +552,10 @@ func (c *collector) collectDatabase(o CollectConfig) {
 		c.getStatements(currdb)
 	}
 	c.getBloat()
+	c.getBtreeBloat(currdb)
+	if o.ExactBloat && !arrayHas(o.Omit, "tables") {
+		c.getExactBloat(currdb, o.ExactBloatSecs, o.ExactBloatMB)
+	}
 
 	// logical replication, added schema v1.2
 	if c.version >= 100000 {
@@ -498,6 +567,16 @@ func (c *collector) collectDatabase(o CollectConfig) {
 	if !arrayHas(o.Omit, "citus") {
 		c.getCitus(currdb, !o.NoSizes)
 	}
+
+	// timescaledb, added in schema 1.11
+	if !arrayHas(o.Omit, "timescaledb") {
+		c.getTimescaleDB(currdb, !o.NoSizes)
+	}
+
+	// shared_buffers contents, added in schema 1.11
+	if o.BufferCache {
+		c.getBufferCache(currdb, !o.NoSizes)
+	}
 }
 
 func arrayHas(arr []string, val string) bool {
@@ -565,10 +644,21 @@ func (c *collector) getSettings() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
+	// this is called before c.version is set
+	var version int
+	if err := c.db.QueryRowContext(ctx, `SELECT current_setting('server_version_num')::integer`).Scan(&version); err != nil {
+		log.Fatalf("server_version_num query failed: %v", err)
+	}
+
 	q := `SELECT name, setting, COALESCE(boot_val,''), source,
-			COALESCE(sourcefile,''), COALESCE(sourceline,0)
+			COALESCE(sourcefile,''), COALESCE(sourceline,0),
+			COALESCE(unit,''), context, vartype, COALESCE(reset_val,''),
+			pending_restart
 		  FROM pg_settings
 		  ORDER BY name ASC`
+	if version < 90500 {
+		q = strings.Replace(q, "pending_restart", "FALSE", 1)
+	}
 	rows, err := c.db.QueryContext(ctx, q)
 	if err != nil {
 		log.Fatalf("pg_settings query failed: %v", err)
@@ -579,7 +669,9 @@ func (c *collector) getSettings() {
 	for rows.Next() {
 		var s pgmetrics.Setting
 		var name, sf, sl string
-		if err := rows.Scan(&name, &s.Setting, &s.BootVal, &s.Source, &sf, &sl); err != nil {
+		if err := rows.Scan(&name, &s.Setting, &s.BootVal, &s.Source, &sf, &sl,
+			&s.Unit, &s.Context, &s.VarType, &s.ResetVal,
+			&s.PendingRestart); err != nil {
 			log.Fatalf("pg_settings query failed: %v", err)
 		}
 		if len(sf) > 0 {
@@ -587,11 +679,16 @@ func (c *collector) getSettings() {
 			if len(sl) > 0 {
 				s.Source += ":" + sl
 			}
+			s.SourceFile = sf
+			s.SourceLine, _ = strconv.Atoi(sl)
 		}
 		if s.Setting == s.BootVal { // if not different from default, omit it
 			s.BootVal = "" // will be omitted from json
 			s.Source = ""  // will be omitted from json
 		}
+		if s.ResetVal == s.Setting {
+			s.ResetVal = "" // will be omitted from json
+		}
 		c.result.Settings[name] = s
 	}
 	if err := rows.Err(); err != nil {
@@ -599,6 +696,66 @@ func (c *collector) getSettings() {
 	}
 }
 
+// getFileSettingErrors collects the entries from the configuration files that
+// could not be applied. Needs superuser by default.
+func (c *collector) getFileSettingErrors() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT COALESCE(sourcefile,''), COALESCE(sourceline,0), seqno,
+			COALESCE(name,''), COALESCE(setting,''), applied, error
+		  FROM pg_file_settings
+		  WHERE error IS NOT NULL
+		  ORDER BY seqno ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		return // don't fail on errors
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f pgmetrics.FileSetting
+		if err := rows.Scan(&f.SourceFile, &f.SourceLine, &f.SeqNo, &f.Name,
+			&f.Setting, &f.Applied, &f.Error); err != nil {
+			log.Fatalf("pg_file_settings query failed: %v", err)
+		}
+		c.result.FileSettingErrors = append(c.result.FileSettingErrors, f)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_file_settings query failed: %v", err)
+	}
+}
+
+// getHBARules collects the rules in pg_hba.conf. Needs superuser by default.
+func (c *collector) getHBARules() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT line_number, COALESCE(type,''), COALESCE(database,'{}'),
+			COALESCE(user_name,'{}'), COALESCE(address,''), COALESCE(netmask,''),
+			COALESCE(auth_method,''), COALESCE(options,'{}'), COALESCE(error,'')
+		  FROM pg_hba_file_rules
+		  ORDER BY line_number ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		return // don't fail on errors
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r pgmetrics.HBARule
+		if err := rows.Scan(&r.LineNumber, &r.Type, pq.Array(&r.Databases),
+			pq.Array(&r.Users), &r.Address, &r.NetMask, &r.AuthMethod,
+			pq.Array(&r.Options), &r.Error); err != nil {
+			log.Fatalf("pg_hba_file_rules query failed: %v", err)
+		}
+		c.result.HBARules = append(c.result.HBARules, r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_hba_file_rules query failed: %v", err)
+	}
+}
+
 func (c *collector) getWALArchiver() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -1102,6 +1259,46 @@ func (c *collector) getActivityv96() {
 	}
 }
 
+// getBackendSSL fills in the SSL information for the backends collected by
+// getActivity.
+func (c *collector) getBackendSSL() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT pid, ssl, COALESCE(version, ''), COALESCE(cipher, ''),
+			COALESCE(bits, 0), COALESCE(client_dn, '')
+		  FROM pg_stat_ssl`
+	if c.version < 120000 { // renamed in v12
+		q = strings.Replace(q, "client_dn", "clientdn", 1)
+	}
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_stat_ssl query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	byPID := make(map[int]*pgmetrics.Backend, len(c.result.Backends))
+	for i := range c.result.Backends {
+		byPID[c.result.Backends[i].PID] = &c.result.Backends[i]
+	}
+	for rows.Next() {
+		var pid int
+		var s pgmetrics.Backend
+		if err := rows.Scan(&pid, &s.SSL, &s.SSLVersion, &s.SSLCipher,
+			&s.SSLBits, &s.SSLClientDN); err != nil {
+			log.Fatalf("pg_stat_ssl query failed: %v", err)
+		}
+		if b, ok := byPID[pid]; ok {
+			b.SSL, b.SSLVersion, b.SSLCipher = s.SSL, s.SSLVersion, s.SSLCipher
+			b.SSLBits, b.SSLClientDN = s.SSLBits, s.SSLClientDN
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_ssl query failed: %v", err)
+	}
+}
+
 func (c *collector) getActivityv94() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -1350,12 +1547,18 @@ func (c *collector) getTables(fillSize bool) {
 			COALESCE(IO.toast_blks_read, 0), COALESCE(IO.toast_blks_hit, 0),
 			COALESCE(IO.tidx_blks_read, 0), COALESCE(IO.tidx_blks_hit, 0),
 			C.relkind, C.relpersistence, C.relnatts, age(C.relfrozenxid),
-			C.relispartition, C.reltablespace, COALESCE(array_to_string(C.relacl, E'\n'), '')
+			C.relispartition, C.reltablespace, COALESCE(array_to_string(C.relacl, E'\n'), ''),
+			C.relreplident,
+			ARRAY(SELECT unnest(C.reloptions) UNION ALL
+				SELECT 'toast.' || unnest(TC.reloptions)),
+			pg_get_userbyid(C.relowner), S.n_ins_since_vacuum
 		  FROM pg_stat_user_tables AS S
 			JOIN pg_statio_user_tables AS IO
 			ON S.relid = IO.relid
 			JOIN pg_class AS C
 			ON C.oid = S.relid
+			LEFT JOIN pg_class AS TC
+			ON C.reltoastrelid = TC.oid
 		  ORDER BY S.relid ASC`
 	if c.version < 90400 { // n_mod_since_analyze only in v9.4+
 		q = strings.Replace(q, "S.n_mod_since_analyze", "0", 1)
@@ -1363,6 +1566,12 @@ func (c *collector) getTables(fillSize bool) {
 	if c.version < 100000 { // relispartition only in v10+
 		q = strings.Replace(q, "C.relispartition", "false", 1)
 	}
+	if c.version < 90400 { // relreplident only in v9.4+
+		q = strings.Replace(q, "C.relreplident", "'d'", 1)
+	}
+	if c.version < 130000 { // n_ins_since_vacuum only in v13+
+		q = strings.Replace(q, "S.n_ins_since_vacuum", "0", 1)
+	}
 	rows, err := c.db.QueryContext(ctx, q)
 	if err != nil {
 		log.Fatalf("pg_stat(io)_user_tables query failed: %v", err)
@@ -1382,7 +1591,8 @@ func (c *collector) getTables(fillSize bool) {
 			&t.HeapBlksRead, &t.HeapBlksHit, &t.IdxBlksRead, &t.IdxBlksHit,
 			&t.ToastBlksRead, &t.ToastBlksHit, &t.TidxBlksRead, &t.TidxBlksHit,
 			&t.RelKind, &t.RelPersistence, &t.RelNAtts, &t.AgeRelFrozenXid,
-			&t.RelIsPartition, &tblspcOID, &t.ACL); err != nil {
+			&t.RelIsPartition, &tblspcOID, &t.ACL, &t.ReplIdent,
+			pq.Array(&t.RelOptions), &t.Owner, &t.NInsSinceVacuum); err != nil {
 			log.Fatalf("pg_stat(io)_user_tables query failed: %v", err)
 		}
 		t.Size = -1  // will be filled in later if asked for
@@ -1419,13 +1629,22 @@ func (c *collector) getIndexes(fillSize bool) {
 			current_database(), S.idx_scan, S.idx_tup_read, S.idx_tup_fetch,
 			pg_stat_get_blocks_fetched(S.indexrelid) - pg_stat_get_blocks_hit(S.indexrelid) AS idx_blks_read,
 			pg_stat_get_blocks_hit(S.indexrelid) AS idx_blks_hit,
-			C.relnatts, AM.amname, C.reltablespace, pg_get_indexdef(S.indexrelid)
+			C.relnatts, AM.amname, C.reltablespace, pg_get_indexdef(S.indexrelid),
+			I.indisunique, I.indisprimary, I.indisvalid,
+			ARRAY(SELECT pg_get_indexdef(S.indexrelid, k, true)
+					FROM generate_series(1, I.indnkeyatts) AS k),
+			COALESCE(C.reloptions, '{}')
 		FROM pg_stat_user_indexes AS S
 			JOIN pg_class AS C
 			ON S.indexrelid = C.oid
 			JOIN pg_am AS AM
 			ON C.relam = AM.oid
+			JOIN pg_index AS I
+			ON S.indexrelid = I.indexrelid
 		ORDER BY S.relid ASC`
+	if c.version < 110000 { // indnkeyatts only in v11+
+		q = strings.Replace(q, "I.indnkeyatts", "I.indnatts", 1)
+	}
 	rows, err := c.db.QueryContext(ctx, q)
 	if err != nil {
 		log.Fatalf("pg_stat_user_indexes query failed: %v", err)
@@ -1440,7 +1659,8 @@ func (c *collector) getIndexes(fillSize bool) {
 			&idx.TableName, &idx.Name, &idx.DBName, &idx.IdxScan,
 			&idx.IdxTupRead, &idx.IdxTupFetch, &idx.IdxBlksRead,
 			&idx.IdxBlksHit, &idx.RelNAtts, &idx.AMName, &tblspcOID,
-			&idx.Definition); err != nil {
+			&idx.Definition, &idx.IsUnique, &idx.IsPrimary, &idx.IsValid,
+			pq.Array(&idx.Columns), pq.Array(&idx.RelOptions)); err != nil {
 			log.Fatalf("pg_stat_user_indexes query failed: %v", err)
 		}
 		idx.Size = -1  // will be filled in later if asked for
@@ -1469,6 +1689,60 @@ func (c *collector) getIndexes(fillSize bool) {
 	}
 }
 
+func (c *collector) getConstraints() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT C.oid, current_database(), N.nspname, C.conrelid, R.relname,
+			C.conname, C.contype,
+			ARRAY(SELECT A.attname::text FROM generate_subscripts(C.conkey, 1) AS k
+					JOIN pg_attribute AS A
+					ON A.attrelid = C.conrelid AND A.attnum = C.conkey[k]
+					ORDER BY k),
+			pg_get_constraintdef(C.oid),
+			COALESCE(FN.nspname, ''), C.confrelid, COALESCE(F.relname, ''),
+			ARRAY(SELECT A.attname::text FROM generate_subscripts(C.confkey, 1) AS k
+					JOIN pg_attribute AS A
+					ON A.attrelid = C.confrelid AND A.attnum = C.confkey[k]
+					ORDER BY k),
+			C.conparentid
+		  FROM pg_constraint AS C
+			JOIN pg_class AS R ON C.conrelid = R.oid
+			JOIN pg_namespace AS N ON R.relnamespace = N.oid
+			LEFT JOIN pg_class AS F ON C.confrelid = F.oid
+			LEFT JOIN pg_namespace AS FN ON F.relnamespace = FN.oid
+		  WHERE C.contype IN ('p', 'u', 'f') AND R.relkind IN ('r', 'p')
+			AND N.nspname NOT IN ('pg_catalog', 'information_schema')
+			AND N.nspname !~ '^pg_toast'
+		  ORDER BY C.oid ASC`
+	if c.version < 110000 { // conparentid only in v11+
+		q = strings.Replace(q, "C.conparentid", "0", 1)
+	}
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_constraint query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var con pgmetrics.Constraint
+		if err := rows.Scan(&con.OID, &con.DBName, &con.SchemaName,
+			&con.TableOID, &con.TableName, &con.Name, &con.Type,
+			pq.Array(&con.Columns), &con.Definition, &con.RefSchemaName,
+			&con.RefTableOID, &con.RefTableName,
+			pq.Array(&con.RefColumns), &con.ParentOID); err != nil {
+			log.Fatalf("pg_constraint query failed: %v", err)
+		}
+		if c.tableOK(con.SchemaName, con.TableName) {
+			c.result.Constraints = append(c.result.Constraints, con)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_constraint query failed: %v", err)
+	}
+}
+
 func (c *collector) getSequences() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -1527,6 +1801,69 @@ func (c *collector) getUserFunctions() {
 	}
 }
 
+func (c *collector) getSecurityDefiners() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT P.oid, N.nspname, P.proname, current_database(),
+			pg_get_function_identity_arguments(P.oid),
+			pg_get_userbyid(P.proowner), COALESCE(P.proconfig, '{}'),
+			COALESCE(array_to_string(P.proacl, E'\n'), '')
+		  FROM pg_proc AS P
+			JOIN pg_namespace AS N ON P.pronamespace = N.oid
+		  WHERE P.prosecdef
+			AND N.nspname NOT IN ('pg_catalog', 'information_schema')
+		  ORDER BY P.oid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Fatalf("pg_proc query failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f pgmetrics.SecurityDefiner
+		if err := rows.Scan(&f.OID, &f.SchemaName, &f.Name, &f.DBName, &f.Args,
+			&f.Owner, pq.Array(&f.Config), &f.ACL); err != nil {
+			log.Fatalf("pg_proc query failed: %v", err)
+		}
+		if c.schemaOK(f.SchemaName) {
+			c.result.SecurityDefiners = append(c.result.SecurityDefiners, f)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_proc query failed: %v", err)
+	}
+}
+
+func (c *collector) getSchemas() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT oid, nspname, current_database(), pg_get_userbyid(nspowner),
+			COALESCE(array_to_string(nspacl, E'\n'), '')
+		  FROM pg_namespace
+		  WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+		  ORDER BY oid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Fatalf("pg_namespace query failed: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s pgmetrics.Schema
+		if err := rows.Scan(&s.OID, &s.Name, &s.DBName, &s.Owner, &s.ACL); err != nil {
+			log.Fatalf("pg_namespace query failed: %v", err)
+		}
+		if c.schemaOK(s.Name) {
+			c.result.Schemas = append(c.result.Schemas, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_namespace query failed: %v", err)
+	}
+}
+
 func (c *collector) getVacuumProgress() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -1634,7 +1971,8 @@ func (c *collector) getReplicationSlotsv94() {
 
 	q := `SELECT slot_name, COALESCE(plugin, ''), slot_type,
 			COALESCE(database, ''), active, xmin, catalog_xmin,
-			restart_lsn, confirmed_flush_lsn, temporary
+			restart_lsn, confirmed_flush_lsn, temporary,
+			COALESCE(wal_status, ''), safe_wal_size
 		  FROM pg_replication_slots
 		  ORDER BY slot_name ASC`
 	if c.version < 90600 { // confirmed_flush_lsn only in v9.6+
@@ -1643,6 +1981,16 @@ func (c *collector) getReplicationSlotsv94() {
 	if c.version < 100000 { // temporary only in v10+
 		q = strings.Replace(q, "temporary", "FALSE", 1)
 	}
+	if c.version < 130000 { // wal_status, safe_wal_size only in v13+
+		q = strings.Replace(q, "COALESCE(wal_status, '')", "''", 1)
+		q = strings.Replace(q, "safe_wal_size", "NULL::bigint", 1)
+	}
+	// the position upto which WAL is currently present
+	curLSN := c.result.WALLSN
+	if c.result.IsInRecovery {
+		curLSN = c.result.LastWALReplayLSN
+	}
+
 	rows, err := c.db.QueryContext(ctx, q)
 	if err != nil {
 		log.Printf("warning: pg_replication_slots query failed: %v", err)
@@ -1654,15 +2002,20 @@ func (c *collector) getReplicationSlotsv94() {
 		var rs pgmetrics.ReplicationSlot
 		var xmin, cXmin sql.NullInt64
 		var rlsn, cflsn sql.NullString
+		var safeWAL sql.NullInt64
 		if err := rows.Scan(&rs.SlotName, &rs.Plugin, &rs.SlotType,
 			&rs.DBName, &rs.Active, &xmin, &cXmin, &rlsn, &cflsn,
-			&rs.Temporary); err != nil {
+			&rs.Temporary, &rs.WALStatus, &safeWAL); err != nil {
 			log.Fatalf("pg_replication_slots query failed: %v", err)
 		}
 		rs.Xmin = int(xmin.Int64)
 		rs.CatalogXmin = int(cXmin.Int64)
 		rs.RestartLSN = rlsn.String
 		rs.ConfirmedFlushLSN = cflsn.String
+		rs.RetainedWAL = lsnDiff(curLSN, rs.RestartLSN)
+		if safeWAL.Valid {
+			rs.SafeWALSize = &safeWAL.Int64
+		}
 		c.result.ReplicationSlots = append(c.result.ReplicationSlots, rs)
 	}
 	if err := rows.Err(); err != nil {
@@ -1670,6 +2023,29 @@ func (c *collector) getReplicationSlotsv94() {
 	}
 }
 
+// lsnDiff returns the number of bytes from lsn b to lsn a, or 0 if either is
+// invalid or b is after a.
+func lsnDiff(a, b string) int64 {
+	parse := func(s string) (uint64, bool) {
+		pos := strings.IndexByte(s, '/')
+		if pos <= 0 {
+			return 0, false
+		}
+		hi, err1 := strconv.ParseUint(s[:pos], 16, 32)
+		lo, err2 := strconv.ParseUint(s[pos+1:], 16, 32)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		return hi<<32 | lo, true
+	}
+	va, ok1 := parse(a)
+	vb, ok2 := parse(b)
+	if !ok1 || !ok2 || vb > va {
+		return 0
+	}
+	return int64(va - vb)
+}
+
 func (c *collector) getDisabledTriggers() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -1723,18 +2099,30 @@ func (c *collector) getStatements(currdb string) {
 		return
 	}
 
+	c.result.Statements = c.queryStatements(c.stmtsLimit, true)
+
+	// workload over an interval, added schema 1.11
+	if c.stmtsInterval > 0 {
+		c.getStatementsInterval()
+	}
+}
+
+// queryStatements returns the top limit (or all, if limit is 0) entries from
+// pg_stat_statements, ordered by execution time. If text is false, the query
+// text is neither fetched nor fingerprinted.
+func (c *collector) queryStatements(limit uint, text bool) []pgmetrics.Statement {
+	lim := sql.NullInt64{Int64: int64(limit), Valid: limit > 0}
 	if c.version >= 130000 {
-		c.getStatementsv13(currdb)
-	} else {
-		c.getStatementsPrev13(currdb)
+		return c.getStatementsv13(lim, text)
 	}
+	return c.getStatementsPrev13(lim, text)
 }
 
-func (c *collector) getStatementsv13(currdb string) {
+func (c *collector) getStatementsv13(limit sql.NullInt64, text bool) (out []pgmetrics.Statement) {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
-	q := `SELECT userid, dbid, queryid, LEFT(COALESCE(query, ''), $1), calls,
+	q := `SELECT userid, dbid, queryid, ` + stmtTextCols + `, calls,
 			total_exec_time, min_exec_time, max_exec_time, stddev_exec_time,
 			rows, shared_blks_hit, shared_blks_read, shared_blks_dirtied,
 			shared_blks_written, local_blks_hit, local_blks_read,
@@ -1744,19 +2132,28 @@ func (c *collector) getStatementsv13(currdb string) {
 			stddev_plan_time, wal_records, wal_fpi, wal_bytes::bigint
 		  FROM pg_stat_statements
 		  ORDER BY total_exec_time DESC
-		  LIMIT $2`
-	rows, err := c.db.QueryContext(ctx, q, c.sqlLength, c.stmtsLimit)
+		  LIMIT $1`
+	args := []interface{}{limit}
+	if text {
+		args = append(args, c.sqlLength, stmtFullTextMax)
+	} else {
+		// pg_stat_statements(false) does not read the query texts at all
+		q = strings.Replace(q, stmtTextCols, "'', NULL", 1)
+		q = strings.Replace(q, "FROM pg_stat_statements", "FROM pg_stat_statements(false)", 1)
+	}
+	rows, err := c.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		log.Printf("warning: pg_stat_statements query failed: %v", err)
 		return
 	}
 	defer rows.Close()
 
-	c.result.Statements = make([]pgmetrics.Statement, 0, c.stmtsLimit)
+	out = make([]pgmetrics.Statement, 0, limit.Int64)
 	for rows.Next() {
 		var s pgmetrics.Statement
 		var queryID sql.NullInt64
-		if err := rows.Scan(&s.UserOID, &s.DBOID, &queryID, &s.Query,
+		var fullText sql.NullString
+		if err := rows.Scan(&s.UserOID, &s.DBOID, &queryID, &s.Query, &fullText,
 			&s.Calls, &s.TotalTime, &s.MinTime, &s.MaxTime, &s.StddevTime,
 			&s.Rows, &s.SharedBlksHit, &s.SharedBlksRead, &s.SharedBlksDirtied,
 			&s.SharedBlksWritten, &s.LocalBlksHit, &s.LocalBlksRead,
@@ -1776,27 +2173,40 @@ func (c *collector) getStatementsv13(currdb string) {
 		}
 		// Query ID, set to 0 if null
 		s.QueryID = queryID.Int64
-		c.result.Statements = append(c.result.Statements, s)
+		// fingerprint the full query text
+		if text {
+			s.Fingerprint = stmtFingerprint(s.Query, fullText)
+		}
+		out = append(out, s)
 	}
 	if err := rows.Err(); err != nil {
 		log.Fatalf("pg_stat_statements failed: %v", err)
 	}
+	return
 }
 
-func (c *collector) getStatementsPrev13(currdb string) {
+func (c *collector) getStatementsPrev13(limit sql.NullInt64, text bool) (out []pgmetrics.Statement) {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
-	q := `SELECT userid, dbid, queryid, LEFT(COALESCE(query, ''), $1), calls, total_time,
-			min_time, max_time, stddev_time, rows, shared_blks_hit,
+	q := `SELECT userid, dbid, queryid, ` + stmtTextCols + `,
+			calls, total_time, min_time, max_time, stddev_time, rows, shared_blks_hit,
 			shared_blks_read, shared_blks_dirtied, shared_blks_written,
 			local_blks_hit, local_blks_read, local_blks_dirtied,
 			local_blks_written, temp_blks_read, temp_blks_written,
 			blk_read_time, blk_write_time
 		  FROM pg_stat_statements
 		  ORDER BY total_time DESC
-		  LIMIT $2`
-	rows, err := c.db.QueryContext(ctx, q, c.sqlLength, c.stmtsLimit)
+		  LIMIT $1`
+	args := []interface{}{limit}
+	if text {
+		args = append(args, c.sqlLength, stmtFullTextMax)
+	} else {
+		// pg_stat_statements(false) does not read the query texts at all
+		q = strings.Replace(q, stmtTextCols, "'', NULL", 1)
+		q = strings.Replace(q, "FROM pg_stat_statements", "FROM pg_stat_statements(false)", 1)
+	}
+	rows, err := c.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		// If we get an error about "min_time" we probably have an old (v1.2)
 		// version of pg_stat_statements which does not have min_time, max_time
@@ -1808,7 +2218,7 @@ func (c *collector) getStatementsPrev13(currdb string) {
 			q = strings.Replace(q, "min_time", "0", 1)
 			q = strings.Replace(q, "max_time", "0", 1)
 			q = strings.Replace(q, "stddev_time", "0", 1)
-			rows, err = c.db.QueryContext(ctx, q, c.sqlLength, c.stmtsLimit)
+			rows, err = c.db.QueryContext(ctx, q, args...)
 		}
 		// If we still have errors, silently give up on querying
 		// pg_stat_statements.
@@ -1819,11 +2229,12 @@ func (c *collector) getStatementsPrev13(currdb string) {
 	}
 	defer rows.Close()
 
-	c.result.Statements = make([]pgmetrics.Statement, 0, c.stmtsLimit)
+	out = make([]pgmetrics.Statement, 0, limit.Int64)
 	for rows.Next() {
 		var s pgmetrics.Statement
 		var queryID sql.NullInt64
-		if err := rows.Scan(&s.UserOID, &s.DBOID, &queryID, &s.Query,
+		var fullText sql.NullString
+		if err := rows.Scan(&s.UserOID, &s.DBOID, &queryID, &s.Query, &fullText,
 			&s.Calls, &s.TotalTime, &s.MinTime, &s.MaxTime, &s.StddevTime,
 			&s.Rows, &s.SharedBlksHit, &s.SharedBlksRead, &s.SharedBlksDirtied,
 			&s.SharedBlksWritten, &s.LocalBlksHit, &s.LocalBlksRead,
@@ -1841,11 +2252,16 @@ func (c *collector) getStatementsPrev13(currdb string) {
 		}
 		// Query ID, set to 0 if null
 		s.QueryID = queryID.Int64
-		c.result.Statements = append(c.result.Statements, s)
+		// fingerprint the full query text
+		if text {
+			s.Fingerprint = stmtFingerprint(s.Query, fullText)
+		}
+		out = append(out, s)
 	}
 	if err := rows.Err(); err != nil {
 		log.Fatalf("pg_stat_statements failed: %v", err)
 	}
+	return
 }
 
 func (c *collector) getWALSegmentSize() (out int) {
@@ -2090,6 +2506,35 @@ SELECT DISTINCT blocked_locks.pid AS blocked_pid, blocking_locks.pid AS blocking
 	}
 }
 
+func (c *collector) getPreparedXacts() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT transaction, gid,
+			COALESCE(EXTRACT(EPOCH FROM prepared)::bigint, 0),
+			COALESCE(owner, ''), COALESCE(database, '')
+		  FROM pg_prepared_xacts
+		  ORDER BY prepared ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_prepared_xacts query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p pgmetrics.PreparedXact
+		if err := rows.Scan(&p.Transaction, &p.GID, &p.Prepared, &p.Owner,
+			&p.DBName); err != nil {
+			log.Fatalf("pg_prepared_xacts query failed: %v", err)
+		}
+		c.result.PreparedXacts = append(c.result.PreparedXacts, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_prepared_xacts query failed: %v", err)
+	}
+}
+
 func (c *collector) getPublications() {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -2104,6 +2549,7 @@ func (c *collector) getPublications() {
 	}
 	defer rows.Close()
 
+	start := len(c.result.Publications)
 	for rows.Next() {
 		var p pgmetrics.Publication
 		if err := rows.Scan(&p.OID, &p.Name, &p.DBName, &p.AllTables, &p.Insert,
@@ -2115,6 +2561,45 @@ func (c *collector) getPublications() {
 	if err := rows.Err(); err != nil {
 		log.Fatalf("pg_publication/pg_publication_tables query failed: %v", err)
 	}
+
+	c.getPublicationTables(c.result.Publications[start:])
+}
+
+// getPublicationTables fills in the names of the tables in each of the given
+// publications, which must all be from the current database.
+func (c *collector) getPublicationTables(pubs []pgmetrics.Publication) {
+	if len(pubs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT pubname, schemaname, tablename FROM pg_publication_tables
+			ORDER BY 1, 2, 3`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		return // don't fail on errors
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pubname, schema, table string
+		if err := rows.Scan(&pubname, &schema, &table); err != nil {
+			log.Fatalf("pg_publication_tables query failed: %v", err)
+		}
+		if !c.tableOK(schema, table) {
+			continue
+		}
+		for i := range pubs {
+			if pubs[i].Name == pubname {
+				pubs[i].Tables = append(pubs[i].Tables, schema+"."+table)
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_publication_tables query failed: %v", err)
+	}
 }
 
 func (c *collector) getSubscriptions() {
@@ -2145,6 +2630,7 @@ func (c *collector) getSubscriptions() {
 	}
 	defer rows.Close()
 
+	start := len(c.result.Subscriptions)
 	for rows.Next() {
 		var s pgmetrics.Subscription
 		var msgSend, msgRecv pq.NullTime
@@ -2161,6 +2647,94 @@ func (c *collector) getSubscriptions() {
 	if err := rows.Err(); err != nil {
 		log.Fatalf("pg_subscription query failed: %v", err)
 	}
+
+	c.getSubscriptionRels(c.result.Subscriptions[start:])
+	if c.version >= 150000 {
+		c.getSubscriptionStats(c.result.Subscriptions[start:])
+	}
+}
+
+// getSubscriptionRels fills in the state of each table in the given
+// subscriptions, which must all be from the current database.
+func (c *collector) getSubscriptionRels(subs []pgmetrics.Subscription) {
+	if len(subs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT sr.srsubid, n.nspname, c.relname, sr.srsubstate,
+			COALESCE(sr.srsublsn::text, ''),
+			EXISTS (SELECT 1 FROM pg_stat_subscription ss
+					WHERE ss.subid = sr.srsubid AND ss.relid = sr.srrelid)
+		  FROM pg_subscription_rel sr
+			JOIN pg_class c ON sr.srrelid = c.oid
+			JOIN pg_namespace n ON c.relnamespace = n.oid
+		  ORDER BY 1, 2, 3`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		return // don't fail on errors
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subid int
+		var t pgmetrics.SubscriptionTable
+		if err := rows.Scan(&subid, &t.SchemaName, &t.Name, &t.State, &t.LSN,
+			&t.HasWorker); err != nil {
+			log.Fatalf("pg_subscription_rel query failed: %v", err)
+		}
+		if !c.tableOK(t.SchemaName, t.Name) {
+			continue
+		}
+		for i := range subs {
+			if subs[i].OID == subid {
+				subs[i].Tables = append(subs[i].Tables, t)
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_subscription_rel query failed: %v", err)
+	}
+}
+
+// getSubscriptionStats fills in the error counts of the given subscriptions,
+// which must all be from the current database. Only for pg v15+.
+func (c *collector) getSubscriptionStats(subs []pgmetrics.Subscription) {
+	if len(subs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT subid, apply_error_count, sync_error_count,
+			COALESCE(EXTRACT(EPOCH FROM stats_reset)::bigint, 0)
+		  FROM pg_stat_subscription_stats`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		return // don't fail on errors
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subid int
+		var apply, sync, reset int64
+		if err := rows.Scan(&subid, &apply, &sync, &reset); err != nil {
+			log.Fatalf("pg_stat_subscription_stats query failed: %v", err)
+		}
+		for i := range subs {
+			if subs[i].OID == subid {
+				subs[i].ApplyErrorCount = apply
+				subs[i].SyncErrorCount = sync
+				subs[i].StatsReset = reset
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_subscription_stats query failed: %v", err)
+	}
 }
 
 func (c *collector) getPartitionInfo() {
@@ -2244,10 +2818,12 @@ func (c *collector) getBloat() {
 		}
 		if t := c.result.TableByName(dbname, schemaname, tablename); t != nil && t.Bloat == -1 {
 			t.Bloat = wastedbytes
+			t.BloatMethod = pgmetrics.BloatMethodEstimate
 		}
 		if indexname != "?" {
 			if i := c.result.IndexByName(dbname, schemaname, indexname); i != nil && i.Bloat == -1 {
 				i.Bloat = wastedibytes
+				i.BloatMethod = pgmetrics.BloatMethodEstimate
 			}
 		}
 	}
@@ -2752,3 +3328,92 @@ FROM (
 ) AS sml
  WHERE sml.relpages - otta > 10 OR ipages - iotta > 15
 `
+
+//------------------------------------------------------------------------------
+// The following query for btree index bloat was adapted from the queries at
+// https://github.com/ioguix/pgsql-bloat-estimation, which are:
+//
+// Copyright (c) 2015-2019, Jehan-Guillaume (ioguix) de Rorthais
+//------------------------------------------------------------------------------
+
+const sqlBtreeBloat = `
+SELECT nspname, idxname,
+  CASE WHEN relpages > est_pages_ff THEN bs*(relpages-est_pages_ff) ELSE 0 END::bigint AS bloat_size,
+  is_na
+FROM (
+  SELECT COALESCE(1 +
+      CEIL(reltuples/FLOOR((bs-pageopqdata-pagehdr)*fillfactor/(100*(4+nulldatahdrwidth)::float))), 0
+    ) AS est_pages_ff,
+    bs, nspname, idxname, relpages, is_na
+  FROM (
+    SELECT maxalign, bs, nspname, idxname, reltuples, relpages, fillfactor,
+      ( index_tuple_hdr_bm +
+          maxalign - CASE
+            WHEN index_tuple_hdr_bm%maxalign = 0 THEN maxalign
+            ELSE index_tuple_hdr_bm%maxalign
+          END
+        + nulldatawidth + maxalign - CASE
+            WHEN nulldatawidth = 0 THEN 0
+            WHEN nulldatawidth::integer%maxalign = 0 THEN maxalign
+            ELSE nulldatawidth::integer%maxalign
+          END
+      )::numeric AS nulldatahdrwidth, pagehdr, pageopqdata, is_na
+    FROM (
+      SELECT n.nspname, i.idxname, i.reltuples, i.relpages, i.fillfactor,
+        current_setting('block_size')::numeric AS bs,
+        CASE
+          WHEN version() ~ 'mingw32' OR version() ~ '64-bit|x86_64|ppc64|ia64|amd64' THEN 8
+          ELSE 4
+        END AS maxalign,
+        24 AS pagehdr,
+        16 AS pageopqdata,
+        CASE WHEN MAX(COALESCE(s.null_frac, 0)) = 0
+          THEN 8 -- IndexTupleData size
+          ELSE 8 + ((32 + 8 - 1) / 8) -- IndexTupleData size + IndexAttributeBitMapData size
+        END AS index_tuple_hdr_bm,
+        SUM((1-COALESCE(s.null_frac, 0)) * COALESCE(s.avg_width, 1024)) AS nulldatawidth,
+        MAX(CASE WHEN i.atttypid = 'pg_catalog.name'::regtype THEN 1 ELSE 0 END) > 0 AS is_na
+      FROM (
+        SELECT ct.relname AS tblname, ct.relnamespace, ic.idxname, ic.attpos, ic.indkey,
+          ic.indkey[ic.attpos], ic.reltuples, ic.relpages, ic.tbloid, ic.idxoid, ic.fillfactor,
+          COALESCE(a1.attnum, a2.attnum) AS attnum,
+          COALESCE(a1.attname, a2.attname) AS attname,
+          COALESCE(a1.atttypid, a2.atttypid) AS atttypid,
+          CASE WHEN a1.attnum IS NULL THEN ic.idxname ELSE ct.relname END AS attrelname
+        FROM (
+          SELECT idxname, reltuples, relpages, tbloid, idxoid, fillfactor, indkey,
+            pg_catalog.generate_series(1, indnatts) AS attpos
+          FROM (
+            SELECT ci.relname AS idxname, ci.reltuples, ci.relpages, i.indrelid AS tbloid,
+              i.indexrelid AS idxoid,
+              COALESCE(SUBSTRING(
+                ARRAY_TO_STRING(ci.reloptions, ' ')
+                FROM 'fillfactor=([0-9]+)')::smallint, 90) AS fillfactor,
+              i.indnatts,
+              pg_catalog.string_to_array(pg_catalog.textin(
+                pg_catalog.int2vectorout(i.indkey)), ' ')::int[] AS indkey
+            FROM pg_catalog.pg_index i
+              JOIN pg_catalog.pg_class ci ON ci.oid = i.indexrelid
+            WHERE ci.relam = (SELECT oid FROM pg_am WHERE amname = 'btree')
+              AND ci.relpages > 0 AND ci.reltuples >= 0
+          ) AS idx_data
+        ) AS ic
+          JOIN pg_catalog.pg_class ct ON ct.oid = ic.tbloid
+          LEFT JOIN pg_catalog.pg_attribute a1 ON
+            ic.indkey[ic.attpos] <> 0
+            AND a1.attrelid = ic.tbloid
+            AND a1.attnum = ic.indkey[ic.attpos]
+          LEFT JOIN pg_catalog.pg_attribute a2 ON
+            ic.indkey[ic.attpos] = 0
+            AND a2.attrelid = ic.idxoid
+            AND a2.attnum = ic.attpos
+      ) i
+        JOIN pg_catalog.pg_namespace n ON n.oid = i.relnamespace
+        JOIN pg_catalog.pg_stats s ON s.schemaname = n.nspname
+          AND s.tablename = i.attrelname
+          AND s.attname = i.attname
+      GROUP BY 1, 2, 3, 4, 5, 6, 7, 8, 9
+    ) AS rows_data_stats
+  ) AS rows_hdr_pdg_stats
+) AS relation_stats
+`
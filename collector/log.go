@@ -23,6 +23,7 @@ var (
 	rxAESwitch2 = regexp.MustCompile(`cost=\d+.*rows=\d`)
 	rxAVStart   = regexp.MustCompile(`automatic (aggressive )?vacuum (to prevent wraparound )?of table "([^"]+)": index`)
 	rxAVElapsed = regexp.MustCompile(`, elapsed: ([0-9.]+) s`)
+	rxSlowQuery = regexp.MustCompile(`(?s)^duration: ([0-9.]+) ms  (?:statement|execute [^:]*): (.*)$`)
 )
 
 func (c *collector) readLogs(filenames []string) {
@@ -276,6 +277,8 @@ func (c *collector) processLogEntry() {
 		c.processAV(sm)
 	} else if c.currLog.line == "deadlock detected" {
 		c.processDeadlock()
+	} else if sm := rxSlowQuery.FindStringSubmatch(c.currLog.line); sm != nil {
+		c.processSlowQuery(sm)
 	}
 }
 
@@ -321,6 +324,9 @@ func (c *collector) processAE(sm []string) {
 			}
 		}
 	}
+	if len(p.Query) > 0 {
+		p.Fingerprint = pgmetrics.Fingerprint(p.Query)
+	}
 	c.result.Plans = append(c.result.Plans, p)
 }
 
@@ -347,6 +353,21 @@ func (c *collector) processDeadlock() {
 	c.result.Deadlocks = append(c.result.Deadlocks, pgmetrics.Deadlock{At: e.t.Unix(), Detail: text})
 }
 
+func (c *collector) processSlowQuery(sm []string) {
+	e := c.currLog
+	duration, _ := strconv.ParseFloat(sm[1], 64)
+	q := strings.TrimSpace(sm[2])
+	sq := pgmetrics.SlowQuery{
+		At:       e.t.Unix(),
+		Database: e.db,
+		UserName: e.user,
+		Duration: duration,
+		Query:    q,
+	}
+	c.fingerprintQuery(&sq.Query, &sq.Fingerprint)
+	c.result.SlowQueries = append(c.result.SlowQueries, sq)
+}
+
 //------------------------------------------------------------------------------
 
 func getMatchData(match [][]byte, prefix *regexp.Regexp) (t time.Time, user, db string, err error) {
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"log"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// progressRelName returns an SQL expression for the name of the relation in
+// the given column of a pg_stat_progress_* view aliased as "p". Relations
+// in other databases cannot be named, and are returned as empty strings.
+func progressRelName(col string) string {
+	return `CASE WHEN p.datname = current_database() AND COALESCE(` + col + `, 0) <> 0
+			  THEN ` + col + `::regclass::text ELSE '' END`
+}
+
+func (c *collector) getCreateIndexProgress() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT p.pid, COALESCE(p.datname, ''), COALESCE(p.relid, 0),
+			` + progressRelName("p.relid") + `,
+			COALESCE(p.index_relid, 0), ` + progressRelName("p.index_relid") + `,
+			COALESCE(p.command, ''), COALESCE(p.phase, ''),
+			COALESCE(p.lockers_total, 0), COALESCE(p.lockers_done, 0),
+			COALESCE(p.current_locker_pid, 0),
+			COALESCE(p.blocks_total, 0), COALESCE(p.blocks_done, 0),
+			COALESCE(p.tuples_total, 0), COALESCE(p.tuples_done, 0),
+			COALESCE(p.partitions_total, 0), COALESCE(p.partitions_done, 0),
+			COALESCE(EXTRACT(EPOCH FROM a.query_start)::bigint, 0)
+		  FROM pg_stat_progress_create_index p
+			LEFT JOIN pg_stat_activity a ON p.pid = a.pid
+		  ORDER BY p.pid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_stat_progress_create_index query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p pgmetrics.CreateIndexProgressBackend
+		if err := rows.Scan(&p.PID, &p.DBName, &p.TableOID, &p.TableName,
+			&p.IndexOID, &p.IndexName, &p.Command, &p.Phase, &p.LockersTotal,
+			&p.LockersDone, &p.CurrentLockerPID, &p.BlocksTotal, &p.BlocksDone,
+			&p.TuplesTotal, &p.TuplesDone, &p.PartitionsTotal,
+			&p.PartitionsDone, &p.QueryStart); err != nil {
+			log.Fatalf("pg_stat_progress_create_index query failed: %v", err)
+		}
+		c.result.CreateIndexProgress = append(c.result.CreateIndexProgress, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_progress_create_index query failed: %v", err)
+	}
+}
+
+func (c *collector) getClusterProgress() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT p.pid, COALESCE(p.datname, ''), COALESCE(p.relid, 0),
+			` + progressRelName("p.relid") + `,
+			COALESCE(p.command, ''), COALESCE(p.phase, ''),
+			COALESCE(p.cluster_index_relid, 0),
+			COALESCE(p.heap_tuples_scanned, 0), COALESCE(p.heap_tuples_written, 0),
+			COALESCE(p.heap_blks_total, 0), COALESCE(p.heap_blks_scanned, 0),
+			COALESCE(p.index_rebuild_count, 0),
+			COALESCE(EXTRACT(EPOCH FROM a.query_start)::bigint, 0)
+		  FROM pg_stat_progress_cluster p
+			LEFT JOIN pg_stat_activity a ON p.pid = a.pid
+		  ORDER BY p.pid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_stat_progress_cluster query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p pgmetrics.ClusterProgressBackend
+		if err := rows.Scan(&p.PID, &p.DBName, &p.TableOID, &p.TableName,
+			&p.Command, &p.Phase, &p.IndexOID, &p.HeapTuplesScanned,
+			&p.HeapTuplesWritten, &p.HeapBlksTotal, &p.HeapBlksScanned,
+			&p.IndexRebuildCount, &p.QueryStart); err != nil {
+			log.Fatalf("pg_stat_progress_cluster query failed: %v", err)
+		}
+		c.result.ClusterProgress = append(c.result.ClusterProgress, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_progress_cluster query failed: %v", err)
+	}
+}
+
+func (c *collector) getAnalyzeProgress() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT p.pid, COALESCE(p.datname, ''), COALESCE(p.relid, 0),
+			` + progressRelName("p.relid") + `, COALESCE(p.phase, ''),
+			COALESCE(p.sample_blks_total, 0), COALESCE(p.sample_blks_scanned, 0),
+			COALESCE(p.ext_stats_total, 0), COALESCE(p.ext_stats_computed, 0),
+			COALESCE(p.child_tables_total, 0), COALESCE(p.child_tables_done, 0),
+			COALESCE(EXTRACT(EPOCH FROM a.query_start)::bigint, 0)
+		  FROM pg_stat_progress_analyze p
+			LEFT JOIN pg_stat_activity a ON p.pid = a.pid
+		  ORDER BY p.pid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_stat_progress_analyze query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p pgmetrics.AnalyzeProgressBackend
+		if err := rows.Scan(&p.PID, &p.DBName, &p.TableOID, &p.TableName,
+			&p.Phase, &p.SampleBlksTotal, &p.SampleBlksScanned, &p.ExtStatsTotal,
+			&p.ExtStatsComputed, &p.ChildTablesTotal, &p.ChildTablesDone,
+			&p.QueryStart); err != nil {
+			log.Fatalf("pg_stat_progress_analyze query failed: %v", err)
+		}
+		c.result.AnalyzeProgress = append(c.result.AnalyzeProgress, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_progress_analyze query failed: %v", err)
+	}
+}
+
+func (c *collector) getBasebackupProgress() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT p.pid, COALESCE(p.phase, ''), COALESCE(p.backup_total, -1),
+			COALESCE(p.backup_streamed, 0), COALESCE(p.tablespaces_total, 0),
+			COALESCE(p.tablespaces_streamed, 0),
+			COALESCE(EXTRACT(EPOCH FROM a.backend_start)::bigint, 0)
+		  FROM pg_stat_progress_basebackup p
+			LEFT JOIN pg_stat_activity a ON p.pid = a.pid
+		  ORDER BY p.pid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_stat_progress_basebackup query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p pgmetrics.BasebackupProgressBackend
+		if err := rows.Scan(&p.PID, &p.Phase, &p.BackupTotal, &p.BackupStreamed,
+			&p.TablespacesTotal, &p.TablespacesStreamed,
+			&p.BackendStart); err != nil {
+			log.Fatalf("pg_stat_progress_basebackup query failed: %v", err)
+		}
+		c.result.BasebackupProgress = append(c.result.BasebackupProgress, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_progress_basebackup query failed: %v", err)
+	}
+}
+
+func (c *collector) getCopyProgress() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT p.pid, COALESCE(p.datname, ''), COALESCE(p.relid, 0),
+			` + progressRelName("p.relid") + `,
+			COALESCE(p.command, ''), COALESCE(p.type, ''),
+			COALESCE(p.bytes_processed, 0), COALESCE(p.bytes_total, 0),
+			COALESCE(p.tuples_processed, 0), COALESCE(p.tuples_excluded, 0),
+			COALESCE(EXTRACT(EPOCH FROM a.query_start)::bigint, 0)
+		  FROM pg_stat_progress_copy p
+			LEFT JOIN pg_stat_activity a ON p.pid = a.pid
+		  ORDER BY p.pid ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: pg_stat_progress_copy query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p pgmetrics.CopyProgressBackend
+		if err := rows.Scan(&p.PID, &p.DBName, &p.TableOID, &p.TableName,
+			&p.Command, &p.Type, &p.BytesProcessed, &p.BytesTotal,
+			&p.TuplesProcessed, &p.TuplesExcluded, &p.QueryStart); err != nil {
+			log.Fatalf("pg_stat_progress_copy query failed: %v", err)
+		}
+		c.result.CopyProgress = append(c.result.CopyProgress, p)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_progress_copy query failed: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+	"github.com/rapidloop/pq"
+)
+
+// stmtKey identifies a pg_stat_statements entry. From pg v14, there can be
+// two entries for the same key, for top-level and nested statements; these
+// are added together.
+type stmtKey struct {
+	userOID int
+	dbOID   int
+	queryID int64
+}
+
+// fingerprintQuery sets the fingerprint from the full text of the query, and
+// then truncates the query to sqlLength characters. Statements, plans and slow
+// queries are all fingerprinted from their full text (see stmtTextCols), so
+// that they can be matched up even if the queries are longer than sqlLength.
+func (c *collector) fingerprintQuery(query, fingerprint *string) {
+	*fingerprint = pgmetrics.Fingerprint(*query)
+	if r := []rune(*query); uint(len(r)) > c.sqlLength {
+		*query = string(r[:c.sqlLength])
+	}
+}
+
+// stmtFullTextMax is the maximum number of characters of a statement's text
+// that are fetched for fingerprinting. Longer statements are fingerprinted from
+// their first stmtFullTextMax characters only.
+const stmtFullTextMax = 65536
+
+// stmtTextCols selects the query text truncated to sqlLength ($2) characters,
+// and the full text (up to stmtFullTextMax ($3) characters) only if the query
+// is longer than that. The full text is used only for the fingerprint.
+const stmtTextCols = `LEFT(COALESCE(query, ''), $2),
+			CASE WHEN length(query) > $2 THEN LEFT(query, $3) END`
+
+// stmtFingerprint returns the fingerprint of a statement, from its full text
+// if it was truncated.
+func stmtFingerprint(query string, fullText sql.NullString) string {
+	if fullText.Valid {
+		return pgmetrics.Fingerprint(fullText.String)
+	}
+	return pgmetrics.Fingerprint(query)
+}
+
+func snapshotStatements(stmts []pgmetrics.Statement) map[stmtKey]pgmetrics.Statement {
+	out := make(map[stmtKey]pgmetrics.Statement, len(stmts))
+	for _, s := range stmts {
+		k := stmtKey{s.UserOID, s.DBOID, s.QueryID}
+		if prev, ok := out[k]; ok {
+			s = addStatement(prev, s)
+		}
+		out[k] = s
+	}
+	return out
+}
+
+// addStatement returns a with the counters of b added to it.
+func addStatement(a, b pgmetrics.Statement) pgmetrics.Statement {
+	a.Calls += b.Calls
+	a.TotalTime += b.TotalTime
+	a.Rows += b.Rows
+	a.SharedBlksHit += b.SharedBlksHit
+	a.SharedBlksRead += b.SharedBlksRead
+	a.SharedBlksDirtied += b.SharedBlksDirtied
+	a.SharedBlksWritten += b.SharedBlksWritten
+	a.LocalBlksHit += b.LocalBlksHit
+	a.LocalBlksRead += b.LocalBlksRead
+	a.LocalBlksDirtied += b.LocalBlksDirtied
+	a.LocalBlksWritten += b.LocalBlksWritten
+	a.TempBlksRead += b.TempBlksRead
+	a.TempBlksWritten += b.TempBlksWritten
+	a.BlkReadTime += b.BlkReadTime
+	a.BlkWriteTime += b.BlkWriteTime
+	a.Plans += b.Plans
+	a.TotalPlanTime += b.TotalPlanTime
+	a.WALRecords += b.WALRecords
+	a.WALFPI += b.WALFPI
+	a.WALBytes += b.WALBytes
+	return a
+}
+
+// subStatement returns the change in the counters from a to b.
+func subStatement(b, a pgmetrics.Statement) pgmetrics.Statement {
+	b.Calls -= a.Calls
+	b.TotalTime -= a.TotalTime
+	b.Rows -= a.Rows
+	b.SharedBlksHit -= a.SharedBlksHit
+	b.SharedBlksRead -= a.SharedBlksRead
+	b.SharedBlksDirtied -= a.SharedBlksDirtied
+	b.SharedBlksWritten -= a.SharedBlksWritten
+	b.LocalBlksHit -= a.LocalBlksHit
+	b.LocalBlksRead -= a.LocalBlksRead
+	b.LocalBlksDirtied -= a.LocalBlksDirtied
+	b.LocalBlksWritten -= a.LocalBlksWritten
+	b.TempBlksRead -= a.TempBlksRead
+	b.TempBlksWritten -= a.TempBlksWritten
+	b.BlkReadTime -= a.BlkReadTime
+	b.BlkWriteTime -= a.BlkWriteTime
+	b.Plans -= a.Plans
+	b.TotalPlanTime -= a.TotalPlanTime
+	b.WALRecords -= a.WALRecords
+	b.WALFPI -= a.WALFPI
+	b.WALBytes -= a.WALBytes
+	return b
+}
+
+// getStatementsDealloc returns the number of times pg_stat_statements entries
+// have been deallocated, or -1 if not available (needs pg v14+).
+func (c *collector) getStatementsDealloc() int64 {
+	if c.version < 140000 {
+		return -1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var dealloc int64
+	q := `SELECT dealloc FROM pg_stat_statements_info`
+	if err := c.db.QueryRowContext(ctx, q).Scan(&dealloc); err != nil {
+		return -1 // don't fail on errors, extension may be older than 1.9
+	}
+	return dealloc
+}
+
+// getStatementsInterval snapshots pg_stat_statements twice, separated by the
+// configured interval, and stores the difference. The snapshots have only the
+// counters; the query texts are fetched later, only for the statements that
+// are kept.
+func (c *collector) getStatementsInterval() {
+	si := pgmetrics.StatementsInterval{Dealloc: -1}
+
+	start := time.Now()
+	dealloc1 := c.getStatementsDealloc()
+	stmts1 := c.queryStatements(0, false)
+	if stmts1 == nil {
+		return
+	}
+	time.Sleep(c.stmtsInterval)
+	end := time.Now()
+	dealloc2 := c.getStatementsDealloc()
+	stmts2 := c.queryStatements(0, false)
+	if stmts2 == nil {
+		return
+	}
+
+	si.Start = start.Unix()
+	si.End = end.Unix()
+	si.Duration = end.Sub(start).Seconds()
+	if dealloc1 >= 0 && dealloc2 >= dealloc1 {
+		si.Dealloc = dealloc2 - dealloc1
+	}
+	if v, err := strconv.Atoi(c.setting("pg_stat_statements.max")); err == nil {
+		si.Max = v
+	}
+
+	snap1, snap2 := snapshotStatements(stmts1), snapshotStatements(stmts2)
+	si.Entries1, si.Entries2 = len(snap1), len(snap2)
+	for k := range snap1 {
+		if _, ok := snap2[k]; !ok {
+			si.Removed++
+		}
+	}
+	for k, s2 := range snap2 {
+		d := s2
+		if s1, ok := snap1[k]; !ok {
+			si.Added++
+		} else if s2.Calls >= s1.Calls {
+			d = subStatement(s2, s1)
+		} // else the entry was deallocated and added again, use s2 as is
+		if d.Calls <= 0 {
+			continue
+		}
+		d.MinTime, d.MaxTime, d.StddevTime = 0, 0, 0
+		d.MinPlanTime, d.MaxPlanTime, d.StddevPlanTime = 0, 0, 0
+		si.Statements = append(si.Statements, d)
+		si.Totals = addStatement(si.Totals, d)
+	}
+	si.Executed = len(si.Statements)
+
+	si.Statements = topStatements(si.Statements, int(c.stmtsLimit))
+	c.getStatementsText(si.Statements)
+	c.result.StatementsInterval = &si
+}
+
+// getStatementsText fetches and fingerprints the query texts of the given
+// statements. Statements that are no longer present in pg_stat_statements are
+// left without text.
+func (c *collector) getStatementsText(stmts []pgmetrics.Statement) {
+	if len(stmts) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	pos := make(map[stmtKey]int, len(stmts))
+	ids := make([]int64, 0, len(stmts))
+	for i, s := range stmts {
+		pos[stmtKey{s.UserOID, s.DBOID, s.QueryID}] = i
+		ids = append(ids, s.QueryID)
+	}
+
+	q := `SELECT userid, dbid, queryid, ` + stmtTextCols + `
+		  FROM pg_stat_statements
+		  WHERE queryid = ANY($1)`
+	rows, err := c.db.QueryContext(ctx, q, pq.Array(ids), c.sqlLength,
+		stmtFullTextMax)
+	if err != nil {
+		log.Printf("warning: pg_stat_statements query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k stmtKey
+		var query string
+		var fullText sql.NullString
+		if err := rows.Scan(&k.userOID, &k.dbOID, &k.queryID, &query,
+			&fullText); err != nil {
+			log.Fatalf("pg_stat_statements scan failed: %v", err)
+		}
+		// there can be two entries with the same key from pg v14, both have
+		// the same text
+		if i, ok := pos[k]; ok && len(stmts[i].Fingerprint) == 0 {
+			stmts[i].Query = query
+			stmts[i].Fingerprint = stmtFingerprint(query, fullText)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("pg_stat_statements failed: %v", err)
+	}
+}
+
+// topStatements returns the statements that are in the top limit by any of
+// calls, time, rows, shared blocks read, temp blocks and WAL bytes, ordered by
+// time.
+func topStatements(stmts []pgmetrics.Statement, limit int) (out []pgmetrics.Statement) {
+	if limit <= 0 || len(stmts) <= limit {
+		out = stmts
+	} else {
+		metrics := []func(s *pgmetrics.Statement) float64{
+			func(s *pgmetrics.Statement) float64 { return float64(s.Calls) },
+			func(s *pgmetrics.Statement) float64 { return s.TotalTime },
+			func(s *pgmetrics.Statement) float64 { return float64(s.Rows) },
+			func(s *pgmetrics.Statement) float64 { return float64(s.SharedBlksRead) },
+			func(s *pgmetrics.Statement) float64 { return float64(s.TempBlksRead + s.TempBlksWritten) },
+			func(s *pgmetrics.Statement) float64 { return float64(s.WALBytes) },
+		}
+		keep := make([]bool, len(stmts))
+		idx := make([]int, len(stmts))
+		for _, m := range metrics {
+			for i := range idx {
+				idx[i] = i
+			}
+			sort.Slice(idx, func(i, j int) bool {
+				return m(&stmts[idx[i]]) > m(&stmts[idx[j]])
+			})
+			for _, i := range idx[:limit] {
+				if m(&stmts[i]) > 0 {
+					keep[i] = true
+				}
+			}
+		}
+		for i := range stmts {
+			if keep[i] {
+				out = append(out, stmts[i])
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].TotalTime > out[j].TotalTime
+	})
+	return
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"log"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Collect only these many of the most recent job errors, per database.
+const timescaleMaxJobErrors = 100
+
+// tsEpoch returns an SQL expression for the timestamp in the given column as
+// seconds since the epoch, or 0 if it is null or infinite.
+func tsEpoch(col string) string {
+	return `COALESCE(CASE WHEN isfinite(` + col + `) THEN EXTRACT(EPOCH FROM ` +
+		col + `)::bigint END, 0)`
+}
+
+func (c *collector) getTimescaleDB(currdb string, fillSize bool) {
+	// check if timescaledb extension is present in current database
+	var version string
+	found := false
+	for _, e := range c.result.Extensions {
+		if e.Name == "timescaledb" && e.DBName == currdb {
+			version = e.InstalledVersion
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	// setup result
+	if c.result.TimescaleDB == nil {
+		c.result.TimescaleDB = make(map[string]*pgmetrics.TimescaleDB)
+	}
+	if c.result.TimescaleDB[currdb] == nil {
+		c.result.TimescaleDB[currdb] = &pgmetrics.TimescaleDB{Version: version}
+	}
+
+	c.getHypertables(currdb, fillSize) // timescaledb_information.hypertables, chunks
+	c.getTimescaleJobs(currdb)         // timescaledb_information.jobs, job_stats
+	c.getTimescaleJobErrors(currdb)    // timescaledb_information.job_errors
+}
+
+func (c *collector) getHypertables(currdb string, fillSize bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `WITH h AS (
+			SELECT hypertable_schema, hypertable_name, owner, num_dimensions,
+				num_chunks, compression_enabled,
+				format('%I.%I', hypertable_schema, hypertable_name)::regclass AS rel
+			  FROM timescaledb_information.hypertables
+		  ), ch AS (
+			SELECT hypertable_schema, hypertable_name,
+				SUM(CASE WHEN is_compressed THEN 1 ELSE 0 END) AS compressed,
+				MIN(range_start) AS range_start, MAX(range_end) AS range_end
+			  FROM timescaledb_information.chunks
+			  GROUP BY hypertable_schema, hypertable_name
+		  )
+		  SELECT h.hypertable_schema, h.hypertable_name, h.owner,
+			h.num_dimensions, h.num_chunks, h.compression_enabled,
+			COALESCE(ch.compressed, 0),
+			CASE WHEN $1 THEN COALESCE(hypertable_size(h.rel), 0) ELSE -1 END,
+			COALESCE(s.before_compression_total_bytes, 0),
+			COALESCE(s.after_compression_total_bytes, 0),
+			` + tsEpoch("ch.range_start") + `, ` + tsEpoch("ch.range_end") + `
+		  FROM h
+			LEFT JOIN ch ON h.hypertable_schema = ch.hypertable_schema
+						AND h.hypertable_name = ch.hypertable_name
+			LEFT JOIN LATERAL (
+				SELECT * FROM hypertable_compression_stats(h.rel)
+				 WHERE h.compression_enabled
+			) s ON true
+		  ORDER BY h.hypertable_schema, h.hypertable_name`
+	rows, err := c.db.QueryContext(ctx, q, fillSize)
+	if err != nil {
+		log.Printf("warning: timescaledb hypertables query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	ts := c.result.TimescaleDB[currdb]
+	for rows.Next() {
+		var h pgmetrics.Hypertable
+		if err := rows.Scan(&h.SchemaName, &h.Name, &h.Owner, &h.NumDimensions,
+			&h.NumChunks, &h.CompressionEnabled, &h.CompressedChunks, &h.Size,
+			&h.BeforeCompression, &h.AfterCompression, &h.RangeStart,
+			&h.RangeEnd); err != nil {
+			log.Fatalf("timescaledb hypertables query failed: %v", err)
+		}
+		if !c.schemaOK(h.SchemaName) {
+			continue
+		}
+		ts.Hypertables = append(ts.Hypertables, h)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("timescaledb hypertables query failed: %v", err)
+	}
+}
+
+func (c *collector) getTimescaleJobs(currdb string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	q := `SELECT j.job_id, j.application_name, j.proc_schema, j.proc_name,
+			COALESCE(j.hypertable_schema, ''), COALESCE(j.hypertable_name, ''),
+			COALESCE(EXTRACT(EPOCH FROM j.schedule_interval)::bigint, 0),
+			j.scheduled, COALESCE(j.config::text, ''),
+			COALESCE(s.last_run_status, ''),
+			` + tsEpoch("s.last_run_started_at") + `,
+			` + tsEpoch("s.last_successful_finish") + `,
+			` + tsEpoch("s.next_start") + `,
+			COALESCE(s.total_runs, 0), COALESCE(s.total_successes, 0),
+			COALESCE(s.total_failures, 0)
+		  FROM timescaledb_information.jobs j
+			LEFT JOIN timescaledb_information.job_stats s ON j.job_id = s.job_id
+		  ORDER BY j.job_id ASC`
+	rows, err := c.db.QueryContext(ctx, q)
+	if err != nil {
+		log.Printf("warning: timescaledb jobs query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	ts := c.result.TimescaleDB[currdb]
+	for rows.Next() {
+		var j pgmetrics.TimescaleJob
+		if err := rows.Scan(&j.ID, &j.ApplicationName, &j.ProcSchema,
+			&j.ProcName, &j.HypertableSchema, &j.HypertableName,
+			&j.ScheduleInterval, &j.Scheduled, &j.Config, &j.LastRunStatus,
+			&j.LastRunStarted, &j.LastSuccessfulFinish, &j.NextStart,
+			&j.TotalRuns, &j.TotalSuccesses, &j.TotalFailures); err != nil {
+			log.Fatalf("timescaledb jobs query failed: %v", err)
+		}
+		ts.Jobs = append(ts.Jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("timescaledb jobs query failed: %v", err)
+	}
+}
+
+func (c *collector) getTimescaleJobErrors(currdb string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	// job_errors is available only in timescaledb v2.9+, so ignore errors
+	q := `SELECT job_id, COALESCE(proc_schema, ''), COALESCE(proc_name, ''),
+			` + tsEpoch("start_time") + `, ` + tsEpoch("finish_time") + `,
+			COALESCE(sqlerrcode, ''), COALESCE(err_message, '')
+		  FROM timescaledb_information.job_errors
+		  ORDER BY finish_time DESC
+		  LIMIT $1`
+	rows, err := c.db.QueryContext(ctx, q, timescaleMaxJobErrors)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ts := c.result.TimescaleDB[currdb]
+	for rows.Next() {
+		var e pgmetrics.TimescaleJobError
+		if err := rows.Scan(&e.JobID, &e.ProcSchema, &e.ProcName, &e.Start,
+			&e.Finish, &e.SQLErrCode, &e.Message); err != nil {
+			log.Fatalf("timescaledb job errors query failed: %v", err)
+		}
+		ts.JobErrors = append(ts.JobErrors, e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("timescaledb job errors query failed: %v", err)
+	}
+}
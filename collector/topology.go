@@ -0,0 +1,303 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rapidloop/pgmetrics"
+)
+
+// Stop discovering more nodes after these many have been found, in case of
+// misconfigured or looping topologies.
+const maxTopologyNodes = 64
+
+// collectTopology starts from the server specified in the config, and follows
+// its upstream and downstream replication connections, as well as those of
+// any extra hosts specified in the config.
+func collectTopology(o CollectConfig, dbnames []string) (nodes []pgmetrics.ReplicationNode) {
+	var dbname string
+	if len(dbnames) > 0 {
+		dbname = dbnames[0]
+	}
+	probe := func(host string, port int) pgmetrics.ReplicationNode {
+		return probeNode(o, dbname, host, port)
+	}
+	find := func(host string, port int) int {
+		host = stripNetmask(host)
+		for i := range nodes {
+			h, p := splitAddress(nodes[i].Address, int(o.Port))
+			if p == port && (h == host || nodes[i].ServerAddr == host) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	// the server itself
+	nodes = append(nodes, probe(o.Host, int(o.Port)))
+
+	// walk upstream till the primary
+	for cur := 0; len(nodes) < maxTopologyNodes; {
+		host, port := upstreamOf(&nodes[cur], int(o.Port))
+		if len(host) == 0 {
+			break
+		}
+		if i := find(host, port); i >= 0 {
+			nodes[cur].Upstream = nodes[i].Address
+			break
+		}
+		up := probe(host, port)
+		nodes[cur].Upstream = up.Address
+		nodes = append(nodes, up)
+		cur = len(nodes) - 1
+	}
+
+	// extra hosts that were explicitly specified
+	for _, h := range o.ReplicaHosts {
+		host, port := splitAddress(h, int(o.Port))
+		if find(host, port) < 0 && len(nodes) < maxTopologyNodes {
+			nodes = append(nodes, probe(host, port))
+		}
+	}
+
+	// walk downstream, breadth first
+	for i := 0; i < len(nodes) && len(nodes) < maxTopologyNodes; i++ {
+		for _, r := range nodes[i].ReplicationOutgoing {
+			host := stripNetmask(r.ClientAddr)
+			if len(host) == 0 {
+				continue // connected via unix socket, can't follow
+			}
+			if j := find(host, int(o.Port)); j >= 0 {
+				if len(nodes[j].Upstream) == 0 && j != i {
+					nodes[j].Upstream = nodes[i].Address
+				}
+				continue
+			}
+			down := probe(host, int(o.Port))
+			if len(down.Error) == 0 && !down.IsInRecovery {
+				continue // not a standby, probably a logical replication subscriber
+			}
+			down.Upstream = nodes[i].Address
+			nodes = append(nodes, down)
+		}
+	}
+
+	// link up the remaining nodes using their upstream conninfo
+	for i := range nodes {
+		if len(nodes[i].Upstream) > 0 {
+			continue
+		}
+		if host, port := upstreamOf(&nodes[i], int(o.Port)); len(host) > 0 {
+			if j := find(host, port); j >= 0 && j != i {
+				nodes[i].Upstream = nodes[j].Address
+			} else {
+				nodes[i].Upstream = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+		}
+	}
+	return
+}
+
+// probeNode connects to the server at host:port and collects the information
+// required to place it in the replication topology. Errors are recorded in
+// the node rather than being fatal.
+func probeNode(o CollectConfig, dbname, host string, port int) (node pgmetrics.ReplicationNode) {
+	node.Address = net.JoinHostPort(host, strconv.Itoa(port))
+	node.At = time.Now().Unix()
+
+	o.Host = host
+	o.Port = uint16(port)
+	connstr := getConnStr(o, false)
+	if len(dbname) > 0 {
+		connstr += makeKV("dbname", dbname)
+	}
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		node.Error = err.Error()
+		return
+	}
+	defer db.Close()
+	db.SetMaxIdleConns(1)
+	db.SetMaxOpenConns(1)
+
+	c := &collector{
+		db:      db,
+		timeout: time.Duration(o.TimeoutSec) * time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		node.Error = err.Error()
+		return
+	}
+
+	// get only the settings needed, cluster_name is not present before v9.5
+	qs := `SELECT name, setting FROM pg_settings
+			WHERE name IN ('server_version_num', 'server_version', 'cluster_name')`
+	rows, err := db.QueryContext(ctx, qs)
+	if err != nil {
+		node.Error = err.Error()
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, setting string
+		if err := rows.Scan(&name, &setting); err != nil {
+			node.Error = err.Error()
+			return
+		}
+		switch name {
+		case "server_version_num":
+			c.version, _ = strconv.Atoi(setting)
+		case "server_version":
+			node.ServerVersion = setting
+		case "cluster_name":
+			node.ClusterName = setting
+		}
+	}
+	if err := rows.Err(); err != nil {
+		node.Error = err.Error()
+		return
+	}
+
+	q := `SELECT pg_is_in_recovery(), COALESCE(host(inet_server_addr()), ''),
+			CASE WHEN pg_is_in_recovery()
+				THEN COALESCE(pg_last_wal_replay_lsn()::text, '')
+				ELSE pg_current_wal_lsn()::text END,
+			COALESCE(EXTRACT(EPOCH FROM pg_last_xact_replay_timestamp())::bigint, 0)`
+	if c.version < 100000 {
+		q = strings.Replace(q, "pg_last_wal_replay_lsn", "pg_last_xlog_replay_location", 1)
+		q = strings.Replace(q, "pg_current_wal_lsn", "pg_current_xlog_location", 1)
+	}
+	if err := db.QueryRowContext(ctx, q).Scan(&node.IsInRecovery, &node.ServerAddr,
+		&node.LSN, &node.LastXActReplayTimestamp); err != nil {
+		node.Error = err.Error()
+		return
+	}
+	if c.version >= 90600 {
+		qs = `SELECT system_identifier FROM pg_control_system()`
+		_ = db.QueryRowContext(ctx, qs).Scan(&node.SystemIdentifier) // ignore errors
+	}
+
+	if c.version >= 100000 {
+		c.getReplicationv10()
+	} else {
+		c.getReplicationv9()
+	}
+	if c.version >= 130000 {
+		c.getWalReceiverv13()
+	} else if c.version >= 90600 {
+		c.getWalReceiverv96()
+	}
+	node.ReplicationOutgoing = c.result.ReplicationOutgoing
+	node.ReplicationIncoming = c.result.ReplicationIncoming
+	return
+}
+
+// upstreamOf returns the host and port that the node is replicating from, as
+// given in the conninfo of its WAL receiver.
+func upstreamOf(node *pgmetrics.ReplicationNode, defPort int) (host string, port int) {
+	if node.ReplicationIncoming == nil {
+		return "", 0
+	}
+	kv := parseConninfo(node.ReplicationIncoming.Conninfo)
+	host = kv["host"]
+	if len(host) == 0 {
+		host = kv["hostaddr"]
+	}
+	if pos := strings.IndexByte(host, ','); pos >= 0 {
+		host = host[:pos] // multiple hosts, use the first
+	}
+	if len(host) == 0 || strings.HasPrefix(host, "/") {
+		return "", 0 // unix socket, can't follow
+	}
+	port = defPort
+	p := kv["port"]
+	if pos := strings.IndexByte(p, ','); pos >= 0 {
+		p = p[:pos]
+	}
+	if v, err := strconv.Atoi(p); err == nil && v > 0 {
+		port = v
+	}
+	return
+}
+
+// parseConninfo parses a libpq key=value connection string. Values may be
+// single-quoted, with backslash escapes.
+func parseConninfo(s string) map[string]string {
+	out := make(map[string]string)
+	for i := 0; i < len(s); {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		var val strings.Builder
+		if i < len(s) && s[i] == '\'' {
+			for i++; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				val.WriteByte(s[i])
+			}
+			i++ // closing quote
+		} else {
+			for ; i < len(s) && s[i] != ' '; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				val.WriteByte(s[i])
+			}
+		}
+		out[key] = val.String()
+	}
+	return out
+}
+
+// splitAddress splits "host:port" or "host" into host and port, using defPort
+// if no port is specified.
+func splitAddress(addr string, defPort int) (string, int) {
+	if host, p, err := net.SplitHostPort(addr); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			return host, port
+		}
+		return host, defPort
+	}
+	return addr, defPort
+}
+
+// stripNetmask removes the "/32" or "/128" suffix from the text form of an
+// inet value.
+func stripNetmask(addr string) string {
+	if pos := strings.IndexByte(addr, '/'); pos > 0 {
+		return addr[:pos]
+	}
+	return addr
+}
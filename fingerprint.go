@@ -0,0 +1,297 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pgmetrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NormalizeQuery returns a normalized form of the SQL query, such that queries
+// that differ only in the values of literals and parameters, the number of
+// items in IN-lists, comments, whitespace and the case of keywords and
+// unquoted identifiers have the same normalized form.
+//
+// Literals (strings, numbers, bit strings, dollar-quoted strings) and
+// parameters ($1) are replaced with "?". Lists of only literals, like
+// "IN (1, 2, 3)" and "ARRAY[1, 2]" are reduced to a single "?", as are
+// multiple such lists, like "VALUES (1, 2), (3, 4)".
+func NormalizeQuery(query string) string {
+	tokens := tokenizeSQL(query)
+
+	// collapse lists of literals within () or [] into a single literal
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t == "(" || t == "[" {
+			if j := endOfLiteralList(tokens, i+1); j > i+1 {
+				out = append(out, t, "?", tokens[j])
+				i = j
+				// also drop more lists that follow, as in VALUES (1), (2)
+				for i+2 < len(tokens) && tokens[i+1] == "," && tokens[i+2] == t {
+					j := endOfLiteralList(tokens, i+3)
+					if j < 0 {
+						break
+					}
+					i = j
+				}
+				continue
+			}
+		}
+		out = append(out, t)
+	}
+	// drop trailing semicolons
+	for len(out) > 0 && out[len(out)-1] == ";" {
+		out = out[:len(out)-1]
+	}
+
+	// join with single spaces, except around brackets, dots and commas
+	var b strings.Builder
+	for i, t := range out {
+		if i > 0 {
+			prev := out[i-1]
+			switch {
+			case prev == "(" || prev == "[" || prev == ".":
+			case t == ")" || t == "[" || t == "]" || t == "," || t == "." || t == "::":
+			case prev == "::":
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// Fingerprint returns a hash of the normalized form of the query, as 16 hex
+// digits. Queries that normalize to the same text have the same fingerprint.
+func Fingerprint(query string) string {
+	h := fnv.New64a()
+	h.Write([]byte(NormalizeQuery(query)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// endOfLiteralList returns the index of the closing bracket if the tokens
+// starting from i are a comma-separated list of literals, else returns -1.
+func endOfLiteralList(tokens []string, i int) int {
+	n := 0
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		if n%2 == 0 {
+			if t != "?" {
+				return -1
+			}
+		} else if t == ")" || t == "]" {
+			return i
+		} else if t != "," {
+			return -1
+		}
+		n++
+	}
+	return -1
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentChar(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenizeSQL splits the query into tokens, dropping whitespace and comments,
+// lowercasing keywords and unquoted identifiers, and replacing literals and
+// parameters with "?". Unterminated literals, quoted identifiers and comments
+// (as in truncated queries) extend to the end of the query.
+func tokenizeSQL(q string) (tokens []string) {
+	for i := 0; i < len(q); {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+
+		// comments
+		case strings.HasPrefix(q[i:], "--"):
+			if j := strings.IndexByte(q[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(q)
+			}
+		case strings.HasPrefix(q[i:], "/*"):
+			i = skipBlockComment(q, i)
+
+		// string literals, with optional E, B, X, N or U& prefix
+		case r == '\'':
+			i = skipQuoted(q, i, '\'', false)
+			tokens = append(tokens, "?")
+		case (r == 'e' || r == 'E') && strings.HasPrefix(q[i+1:], "'"):
+			i = skipQuoted(q, i+1, '\'', true)
+			tokens = append(tokens, "?")
+		case strings.ContainsRune("bBxXnN", r) && strings.HasPrefix(q[i+1:], "'"):
+			i = skipQuoted(q, i+1, '\'', false)
+			tokens = append(tokens, "?")
+		case (r == 'u' || r == 'U') && strings.HasPrefix(q[i+1:], "&'"):
+			i = skipQuoted(q, i+2, '\'', false)
+			tokens = append(tokens, "?")
+
+		// quoted identifiers, kept as is
+		case r == '"':
+			j := skipQuoted(q, i, '"', false)
+			tokens = append(tokens, q[i:j])
+			i = j
+
+		// parameters and dollar-quoted strings
+		case r == '$':
+			j := i + 1
+			for j < len(q) && q[j] >= '0' && q[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				tokens = append(tokens, "?")
+				i = j
+				break
+			}
+			if tag := dollarTag(q[i:]); len(tag) > 0 {
+				if k := strings.Index(q[i+len(tag):], tag); k >= 0 {
+					i += len(tag) + k + len(tag)
+				} else {
+					i = len(q)
+				}
+				tokens = append(tokens, "?")
+				break
+			}
+			tokens = append(tokens, "$")
+			i++
+
+		// numbers
+		case r >= '0' && r <= '9' || (r == '.' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9'):
+			i = skipNumber(q, i)
+			tokens = append(tokens, "?")
+
+		// keywords and identifiers
+		case isIdentStart(r):
+			j := i + size
+			for j < len(q) {
+				r2, s2 := utf8.DecodeRuneInString(q[j:])
+				if !isIdentChar(r2) {
+					break
+				}
+				j += s2
+			}
+			tokens = append(tokens, strings.ToLower(q[i:j]))
+			i = j
+
+		// operators, multi-character ones kept together
+		case strings.ContainsRune("+-*/<>=~!@#%^&|`?", r):
+			j := i + 1
+			for j < len(q) && strings.IndexByte("+-*/<>=~!@#%^&|`?", q[j]) >= 0 &&
+				!strings.HasPrefix(q[j:], "--") && !strings.HasPrefix(q[j:], "/*") {
+				j++
+			}
+			tokens = append(tokens, q[i:j])
+			i = j
+		case strings.HasPrefix(q[i:], "::"):
+			tokens = append(tokens, "::")
+			i += 2
+
+		// punctuation and anything else
+		default:
+			tokens = append(tokens, q[i:i+size])
+			i += size
+		}
+	}
+	return
+}
+
+// skipQuoted returns the index after the closing quote of the literal or
+// identifier starting at q[i]. Doubled quotes are part of the value, as are
+// backslash-escaped ones if backslash is set.
+func skipQuoted(q string, i int, quote byte, backslash bool) int {
+	for j := i + 1; j < len(q); j++ {
+		switch {
+		case backslash && q[j] == '\\':
+			j++
+		case q[j] == quote:
+			if j+1 < len(q) && q[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(q)
+}
+
+// skipBlockComment returns the index after the end of the (possibly nested)
+// comment starting at q[i].
+func skipBlockComment(q string, i int) int {
+	depth := 0
+	for j := i; j+1 < len(q); j++ {
+		switch q[j : j+2] {
+		case "/*":
+			depth++
+			j++
+		case "*/":
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(q)
+}
+
+// dollarTag returns the opening tag ("$$" or "$tag$") if s starts with one.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		if c == '$' {
+			return s[:j+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			(j > 1 && c >= '0' && c <= '9') || c >= 0x80) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// skipNumber returns the index after the end of the numeric literal starting
+// at q[i].
+func skipNumber(q string, i int) int {
+	j := i
+	for j < len(q) && (q[j] >= '0' && q[j] <= '9' || q[j] == '.' || q[j] == '_') {
+		j++
+	}
+	if j < len(q) && (q[j] == 'e' || q[j] == 'E') {
+		k := j + 1
+		if k < len(q) && (q[k] == '+' || q[k] == '-') {
+			k++
+		}
+		if k < len(q) && q[k] >= '0' && q[k] <= '9' {
+			j = k
+			for j < len(q) && q[j] >= '0' && q[j] <= '9' {
+				j++
+			}
+		}
+	}
+	return j
+}
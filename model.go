@@ -18,6 +18,16 @@ package pgmetrics
 
 // ModelSchemaVersion is the schema version of the "Model" data structure
 // defined below. It is in the "semver" notation. Version history:
+//    1.11 - prepared transactions, index flags and columns, constraints,
+//				reloptions, more setting attributes, config file errors,
+//				hba rules, schemas, security definer functions, security findings,
+//				backend ssl info, replication topology, slot retention,
+//				subscription table states and errors, publication tables,
+//				blocking tree, activity samples, statements interval,
+//				query fingerprints, slow queries, statement tables,
+//				create index/cluster/analyze/basebackup/copy progress,
+//				buffer cache contents, bloat method, btree bloat estimate,
+//				timescaledb support
 //    1.10 - New fields in pg_stat_statements for Postgres 13
 //    1.9 - Postgres 13, Citus support
 //    1.8 - AWS RDS/EnhancedMonitoring metrics, index defn,
@@ -30,7 +40,7 @@ package pgmetrics
 //    1.2 - more table and index attributes
 //    1.1 - added NotificationQueueUsage and Statements
 //    1.0 - initial release
-const ModelSchemaVersion = "1.10"
+const ModelSchemaVersion = "1.11"
 
 // Model contains the entire information collected by a single run of
 // pgmetrics. It can be converted to and from json without loss of
@@ -151,6 +161,56 @@ type Model struct {
 
 	// citus-related information, per db
 	Citus map[string]*Citus `json:"citus,omitempty"`
+
+	// following fields are present only in schema 1.11 and later
+
+	// prepared (two-phase commit) transactions
+	PreparedXacts []PreparedXact `json:"prepared_xacts,omitempty"`
+
+	// primary key, unique and foreign key constraints (database-specific)
+	Constraints []Constraint `json:"constraints,omitempty"`
+
+	// entries in the configuration files that could not be applied
+	FileSettingErrors []FileSetting `json:"file_setting_errors,omitempty"`
+
+	// client authentication rules from pg_hba.conf
+	HBARules []HBARule `json:"hba_rules,omitempty"`
+
+	// schemas and security definer functions (database-specific)
+	Schemas          []Schema          `json:"schemas,omitempty"`
+	SecurityDefiners []SecurityDefiner `json:"security_definers,omitempty"`
+
+	// results of the security audit, computed from the rest of the model
+	SecurityFindings []SecurityFinding `json:"security_findings,omitempty"`
+
+	// servers connected to by replication, collected only if asked for
+	ReplicationTopology []ReplicationNode `json:"replication_topology,omitempty"`
+
+	// backends waiting on locks, arranged as trees rooted at the backends
+	// that are blocking others, computed from the rest of the model
+	BlockingTree []BlockingNode `json:"blocking_tree,omitempty"`
+
+	// aggregated samples of pg_stat_activity, collected only if asked for
+	ActivitySamples *ActivitySamples `json:"activity_samples,omitempty"`
+
+	// pg_stat_statements activity over an interval, collected only if asked for
+	StatementsInterval *StatementsInterval `json:"statements_interval,omitempty"`
+
+	// statements logged for exceeding log_min_duration_statement
+	SlowQueries []SlowQuery `json:"slow_queries,omitempty"`
+
+	// progress of commands other than vacuum
+	CreateIndexProgress []CreateIndexProgressBackend `json:"create_index_progress,omitempty"`
+	ClusterProgress     []ClusterProgressBackend     `json:"cluster_progress,omitempty"`
+	AnalyzeProgress     []AnalyzeProgressBackend     `json:"analyze_progress,omitempty"`
+	BasebackupProgress  []BasebackupProgressBackend  `json:"basebackup_progress,omitempty"`
+	CopyProgress        []CopyProgressBackend        `json:"copy_progress,omitempty"`
+
+	// contents of shared_buffers, collected only if asked for
+	BufferCache *BufferCache `json:"buffercache,omitempty"`
+
+	// timescaledb-related information, per db
+	TimescaleDB map[string]*TimescaleDB `json:"timescaledb,omitempty"`
 }
 
 // DatabaseByOID iterates over the databases in the model and returns the reference
@@ -251,6 +311,13 @@ type Backend struct {
 	BackendXid      int    `json:"backend_xid"`
 	BackendXmin     int    `json:"backend_xmin"`
 	Query           string `json:"query"`
+	// following fields present only in schema 1.11 and later, from
+	// pg_stat_ssl (Postgres v9.5 and above)
+	SSL         bool   `json:"ssl"`
+	SSLVersion  string `json:"ssl_version,omitempty"` // like "TLSv1.3"
+	SSLCipher   string `json:"ssl_cipher,omitempty"`
+	SSLBits     int    `json:"ssl_bits,omitempty"`
+	SSLClientDN string `json:"ssl_client_dn,omitempty"`
 }
 
 type ReplicationSlot struct {
@@ -264,6 +331,19 @@ type ReplicationSlot struct {
 	RestartLSN        string `json:"restart_lsn"`
 	ConfirmedFlushLSN string `json:"confirmed_flush_lsn"`
 	Temporary         bool   `json:"temporary"`
+
+	// following fields present only in schema 1.11 and later
+
+	// WAL retained by the slot, in bytes, computed as the difference between
+	// restart_lsn and the current WAL LSN (or the last replayed LSN, if in
+	// recovery). 0 if not known.
+	RetainedWAL int64 `json:"retained_wal,omitempty"`
+	// wal_status and safe_wal_size, only from pg v13. SafeWALSize is nil if
+	// it is not known: before v13, for lost slots, and if
+	// max_slot_wal_keep_size is not set. It is negative if the slot has
+	// retained more WAL than max_slot_wal_keep_size.
+	WALStatus   string `json:"wal_status,omitempty"`
+	SafeWALSize *int64 `json:"safe_wal_size,omitempty"`
 }
 
 type Role struct {
@@ -365,6 +445,12 @@ type Table struct {
 	PartitionCV     string `json:"partition_cv"` // partition constraint value
 	// following fields present only in schema 1.7 and later
 	ACL string `json:"acl,omitempty"`
+	// following fields present only in schema 1.11 and later
+	ReplIdent       string   `json:"relreplident,omitempty"` // d=default, n=nothing, f=full, i=index
+	RelOptions      []string `json:"reloptions,omitempty"`   // "name=value", toast options prefixed with "toast."
+	Owner           string   `json:"owner,omitempty"`
+	BloatMethod     string   `json:"bloat_method,omitempty"`       // how Bloat was computed, see BloatMethod* constants
+	NInsSinceVacuum int64    `json:"n_ins_since_vacuum,omitempty"` // only in pg v13+
 }
 
 type Index struct {
@@ -387,8 +473,27 @@ type Index struct {
 	TablespaceName string `json:"tablespace_name"`
 	// following fields present only in schema 1.8 and later
 	Definition string `json:"def"`
+	// following fields present only in schema 1.11 and later
+	IsUnique    bool     `json:"indisunique"`
+	IsPrimary   bool     `json:"indisprimary"`
+	IsValid     bool     `json:"indisvalid"`
+	Columns     []string `json:"columns,omitempty"`      // key columns or expressions
+	RelOptions  []string `json:"reloptions,omitempty"`   // "name=value"
+	BloatMethod string   `json:"bloat_method,omitempty"` // how Bloat was computed, see BloatMethod* constants
 }
 
+// Methods used to compute the Bloat values of tables and indexes.
+const (
+	// estimated from table statistics, using the query from check_postgres
+	BloatMethodEstimate = "estimate"
+	// estimated for btree indexes from the statistics of the indexed columns
+	BloatMethodBtreeEstimate = "btree_estimate"
+	// measured using pgstattuple_approx() from the pgstattuple extension
+	BloatMethodPgstattupleApprox = "pgstattuple_approx"
+	// measured using pgstatindex() from the pgstattuple extension
+	BloatMethodPgstatindex = "pgstatindex"
+)
+
 type Sequence struct {
 	OID        int    `json:"oid"`
 	DBName     string `json:"db_name"`
@@ -421,6 +526,117 @@ type VacuumProgressBackend struct {
 	NumDeadTuples    int64  `json:"num_dead_tuples"`
 }
 
+// CreateIndexProgressBackend represents a row (and each row represents one
+// backend) of pg_stat_progress_create_index (v12+). Added in schema 1.11.
+type CreateIndexProgressBackend struct {
+	PID              int    `json:"pid"`
+	DBName           string `json:"db_name"`
+	TableOID         int    `json:"table_oid"`
+	TableName        string `json:"table_name"` // empty if not in the current database
+	IndexOID         int    `json:"index_oid"`
+	IndexName        string `json:"index_name"` // empty if not in the current database
+	Command          string `json:"command"`
+	Phase            string `json:"phase"`
+	LockersTotal     int64  `json:"lockers_total"`
+	LockersDone      int64  `json:"lockers_done"`
+	CurrentLockerPID int    `json:"current_locker_pid"`
+	BlocksTotal      int64  `json:"blocks_total"`
+	BlocksDone       int64  `json:"blocks_done"`
+	TuplesTotal      int64  `json:"tuples_total"`
+	TuplesDone       int64  `json:"tuples_done"`
+	PartitionsTotal  int64  `json:"partitions_total"`
+	PartitionsDone   int64  `json:"partitions_done"`
+	QueryStart       int64  `json:"query_start"` // seconds since epoch, 0 if unknown
+}
+
+// ClusterProgressBackend represents a row (and each row represents one
+// backend) of pg_stat_progress_cluster (v12+). Added in schema 1.11.
+type ClusterProgressBackend struct {
+	PID               int    `json:"pid"`
+	DBName            string `json:"db_name"`
+	TableOID          int    `json:"table_oid"`
+	TableName         string `json:"table_name"` // empty if not in the current database
+	Command           string `json:"command"`    // CLUSTER or VACUUM FULL
+	Phase             string `json:"phase"`
+	IndexOID          int    `json:"cluster_index_oid"`
+	HeapTuplesScanned int64  `json:"heap_tuples_scanned"`
+	HeapTuplesWritten int64  `json:"heap_tuples_written"`
+	HeapBlksTotal     int64  `json:"heap_blks_total"`
+	HeapBlksScanned   int64  `json:"heap_blks_scanned"`
+	IndexRebuildCount int64  `json:"index_rebuild_count"`
+	QueryStart        int64  `json:"query_start"` // seconds since epoch, 0 if unknown
+}
+
+// AnalyzeProgressBackend represents a row (and each row represents one
+// backend) of pg_stat_progress_analyze (v13+). Added in schema 1.11.
+type AnalyzeProgressBackend struct {
+	PID               int    `json:"pid"`
+	DBName            string `json:"db_name"`
+	TableOID          int    `json:"table_oid"`
+	TableName         string `json:"table_name"` // empty if not in the current database
+	Phase             string `json:"phase"`
+	SampleBlksTotal   int64  `json:"sample_blks_total"`
+	SampleBlksScanned int64  `json:"sample_blks_scanned"`
+	ExtStatsTotal     int64  `json:"ext_stats_total"`
+	ExtStatsComputed  int64  `json:"ext_stats_computed"`
+	ChildTablesTotal  int64  `json:"child_tables_total"`
+	ChildTablesDone   int64  `json:"child_tables_done"`
+	QueryStart        int64  `json:"query_start"` // seconds since epoch, 0 if unknown
+}
+
+// BasebackupProgressBackend represents a row (and each row represents one
+// wal sender) of pg_stat_progress_basebackup (v13+). Added in schema 1.11.
+type BasebackupProgressBackend struct {
+	PID                 int    `json:"pid"`
+	Phase               string `json:"phase"`
+	BackupTotal         int64  `json:"backup_total"` // bytes, -1 if not estimated
+	BackupStreamed      int64  `json:"backup_streamed"`
+	TablespacesTotal    int64  `json:"tablespaces_total"`
+	TablespacesStreamed int64  `json:"tablespaces_streamed"`
+	BackendStart        int64  `json:"backend_start"` // seconds since epoch, 0 if unknown
+}
+
+// CopyProgressBackend represents a row (and each row represents one
+// backend) of pg_stat_progress_copy (v14+). Added in schema 1.11.
+type CopyProgressBackend struct {
+	PID             int    `json:"pid"`
+	DBName          string `json:"db_name"`
+	TableOID        int    `json:"table_oid"`
+	TableName       string `json:"table_name"` // empty if not in the current database
+	Command         string `json:"command"`    // COPY FROM or COPY TO
+	Type            string `json:"type"`       // FILE, PROGRAM, PIPE or CALLBACK
+	BytesProcessed  int64  `json:"bytes_processed"`
+	BytesTotal      int64  `json:"bytes_total"` // 0 if not known
+	TuplesProcessed int64  `json:"tuples_processed"`
+	TuplesExcluded  int64  `json:"tuples_excluded"`
+	QueryStart      int64  `json:"query_start"` // seconds since epoch, 0 if unknown
+}
+
+// BufferCache is a summary of the contents of shared_buffers, from the
+// pg_buffercache extension. Added in schema 1.11.
+type BufferCache struct {
+	Buffers     int64                 `json:"buffers"`      // total number of buffers
+	Used        int64                 `json:"used"`         // buffers that hold a block of some relation
+	Dirty       int64                 `json:"dirty"`        // buffers modified but not yet written out
+	UsageCounts []int64               `json:"usage_counts"` // count of used buffers with usage count 0, 1, .. 5
+	Relations   []BufferCacheRelation `json:"relations,omitempty"`
+}
+
+// BufferCacheRelation is the summary of the buffers that hold blocks of a
+// single relation (database-specific, except for shared catalogs, which have
+// an empty DBName). Added in schema 1.11.
+type BufferCacheRelation struct {
+	OID         int     `json:"oid"`
+	DBName      string  `json:"db_name"`
+	SchemaName  string  `json:"schema_name"`
+	Name        string  `json:"name"`
+	RelKind     string  `json:"relkind"`
+	Buffers     int64   `json:"buffers"`      // buffers of the main fork only
+	Dirty       int64   `json:"dirty"`        // dirty buffers of the main fork
+	UsageCounts []int64 `json:"usage_counts"` // count of buffers with usage count 0, 1, .. 5
+	Size        int64   `json:"size"`         // size of the main fork, -1 if not collected
+}
+
 type Extension struct {
 	Name             string `json:"name"`
 	DBName           string `json:"db_name"`
@@ -433,6 +649,27 @@ type Setting struct {
 	Setting string `json:"setting"`
 	BootVal string `json:"bootval,omitempty"`
 	Source  string `json:"source,omitempty"`
+	// following fields present only in schema 1.11 and later
+	Unit           string `json:"unit,omitempty"`            // unit of Setting, like "8kB" or "ms"
+	Context        string `json:"context,omitempty"`         // postmaster, sighup, user etc.
+	VarType        string `json:"vartype,omitempty"`         // bool, enum, integer, real or string
+	PendingRestart bool   `json:"pending_restart,omitempty"` // changed in file, needs restart
+	SourceFile     string `json:"sourcefile,omitempty"`
+	SourceLine     int    `json:"sourceline,omitempty"`
+	ResetVal       string `json:"reset_val,omitempty"` // set only if different from Setting
+}
+
+// FileSetting is an entry from pg_file_settings that could not be applied,
+// either because of an error in the configuration file or because the
+// setting can be changed only with a server restart. Added in schema 1.11.
+type FileSetting struct {
+	SourceFile string `json:"sourcefile"`
+	SourceLine int    `json:"sourceline"`
+	SeqNo      int    `json:"seqno"`
+	Name       string `json:"name"`
+	Setting    string `json:"setting"`
+	Applied    bool   `json:"applied"`
+	Error      string `json:"error"`
 }
 
 type WALArchiving struct {
@@ -542,6 +779,9 @@ type Statement struct {
 	WALRecords     int64   `json:"wal_records"`      // Total number of WAL records generated by the statement
 	WALFPI         int64   `json:"wal_fpi"`          // Total number of WAL full page images generated by the statement
 	WALBytes       int64   `json:"wal_bytes"`        // Total amount of WAL bytes generated by the statement
+	// following fields present only in schema 1.11 and later
+	Fingerprint string   `json:"fingerprint,omitempty"` // Hash of the normalized (untruncated) query text, see Fingerprint()
+	Tables      []string `json:"tables,omitempty"`      // Tables referenced by the query, as "schema.table"
 }
 
 // Publication represents a single v10+ publication. Added in schema 1.2.
@@ -554,6 +794,11 @@ type Publication struct {
 	Update     bool   `json:"update"`
 	Delete     bool   `json:"delete"`
 	TableCount int    `json:"table_count"`
+
+	// following fields present only in schema 1.11 and later
+
+	// Tables in the publication, as "schema.table".
+	Tables []string `json:"tables,omitempty"`
 }
 
 // Subscription represents a single v10+ subscription. Added in schema 1.2.
@@ -571,6 +816,30 @@ type Subscription struct {
 	LastMsgReceiptTime int64  `json:"last_msg_receipt_time"`
 	LatestEndTime      int64  `json:"latest_end_time"`
 	Latency            int64  `json:"latency_micros"`
+
+	// following fields present only in schema 1.11 and later
+
+	// Per-table state, from pg_subscription_rel.
+	Tables []SubscriptionTable `json:"tables,omitempty"`
+	// Error counts, from pg_stat_subscription_stats. Only from pg v15.
+	ApplyErrorCount int64 `json:"apply_error_count,omitempty"`
+	SyncErrorCount  int64 `json:"sync_error_count,omitempty"`
+	StatsReset      int64 `json:"stats_reset,omitempty"`
+}
+
+// SubscriptionTable is the state of a single table in a subscription, from
+// pg_subscription_rel. Added in schema 1.11.
+type SubscriptionTable struct {
+	SchemaName string `json:"schema_name"`
+	Name       string `json:"name"`
+	// State is one of i (initialize), d (data is being copied), f (finished
+	// table copy, v15+), s (synchronized) or r (ready).
+	State string `json:"state"`
+	// LSN is the remote LSN of the state change, for s and r states.
+	LSN string `json:"lsn,omitempty"`
+	// HasWorker is true if a table synchronization worker is running for this
+	// table.
+	HasWorker bool `json:"has_worker"`
 }
 
 // Lock represents a single row from pg_locks. Added in schema 1.3.
@@ -661,6 +930,19 @@ type Plan struct {
 	At       int64  `json:"at"`      // time when plan was logged, as seconds since epoch
 	Query    string `json:"query"`   // the sql query
 	Plan     string `json:"plan"`    // the plan as a string
+	// following fields present only in schema 1.11 and later
+	Fingerprint string `json:"fingerprint,omitempty"` // hash of the normalized (untruncated) query
+}
+
+// SlowQuery is a statement that was logged because it ran for longer than
+// log_min_duration_statement. Added in schema 1.11.
+type SlowQuery struct {
+	At          int64   `json:"at"`          // time when query was logged, as seconds since epoch
+	Database    string  `json:"db_name"`     // might be empty
+	UserName    string  `json:"user"`        // might be empty
+	Duration    float64 `json:"duration"`    // in milliseconds
+	Query       string  `json:"query"`       // the sql query, possibly truncated
+	Fingerprint string  `json:"fingerprint"` // hash of the normalized (untruncated) query
 }
 
 // AutoVacuum contains information about a single autovacuum run.
@@ -745,3 +1027,248 @@ type CitusLock struct {
 	WaitingNodePort  int    `json:"waiting_node_port"`
 	BlockingNodePort int    `json:"blocking_node_port"`
 }
+
+// TimescaleDB contains information collected from the TimescaleDB extension.
+// Added in schema 1.11.
+type TimescaleDB struct {
+	Version     string              `json:"version"`
+	Hypertables []Hypertable        `json:"hypertables"`
+	Jobs        []TimescaleJob      `json:"jobs"`
+	JobErrors   []TimescaleJobError `json:"job_errors,omitempty"`
+}
+
+// Hypertable represents a single row from timescaledb_information.hypertables,
+// along with its size and compression statistics. Added in schema 1.11.
+type Hypertable struct {
+	SchemaName         string `json:"schema_name"`
+	Name               string `json:"name"`
+	Owner              string `json:"owner"`
+	NumDimensions      int    `json:"num_dimensions"`
+	NumChunks          int    `json:"num_chunks"`
+	CompressionEnabled bool   `json:"compression_enabled"`
+	// number of chunks that are compressed
+	CompressedChunks int `json:"compressed_chunks"`
+	// total size, including indexes and toast, -1 if not collected
+	Size int64 `json:"size"`
+	// size of the compressed chunks before and after compression
+	BeforeCompression int64 `json:"before_compression"`
+	AfterCompression  int64 `json:"after_compression"`
+	// range of the time dimension covered by the chunks, 0 if not a time
+	RangeStart int64 `json:"range_start"`
+	RangeEnd   int64 `json:"range_end"`
+}
+
+// TimescaleJob represents a background job, like a compression or retention
+// policy, from timescaledb_information.jobs and job_stats. Added in schema 1.11.
+type TimescaleJob struct {
+	ID                   int    `json:"job_id"`
+	ApplicationName      string `json:"application_name"`
+	ProcSchema           string `json:"proc_schema"`
+	ProcName             string `json:"proc_name"`
+	HypertableSchema     string `json:"hypertable_schema"` // empty if not a policy on a hypertable
+	HypertableName       string `json:"hypertable_name"`
+	ScheduleInterval     int64  `json:"schedule_interval"` // in seconds
+	Scheduled            bool   `json:"scheduled"`
+	Config               string `json:"config"` // job configuration, as json
+	LastRunStatus        string `json:"last_run_status"`
+	LastRunStarted       int64  `json:"last_run_started"`
+	LastSuccessfulFinish int64  `json:"last_successful_finish"`
+	NextStart            int64  `json:"next_start"`
+	TotalRuns            int64  `json:"total_runs"`
+	TotalSuccesses       int64  `json:"total_successes"`
+	TotalFailures        int64  `json:"total_failures"`
+}
+
+// TimescaleJobError represents a recent failure of a background job, from
+// timescaledb_information.job_errors (timescaledb v2.9+). Added in schema 1.11.
+type TimescaleJobError struct {
+	JobID      int    `json:"job_id"`
+	ProcSchema string `json:"proc_schema"`
+	ProcName   string `json:"proc_name"`
+	Start      int64  `json:"start"`
+	Finish     int64  `json:"finish"`
+	SQLErrCode string `json:"sqlerrcode"`
+	Message    string `json:"message"`
+}
+
+// PreparedXact represents a single row from pg_prepared_xacts, a transaction
+// that has been prepared for two-phase commit. Added in schema 1.11.
+type PreparedXact struct {
+	Transaction int    `json:"transaction"` // xid of the prepared transaction
+	GID         string `json:"gid"`         // global identifier assigned to it
+	Prepared    int64  `json:"prepared"`    // time when it was prepared, as seconds since epoch
+	Owner       string `json:"owner"`       // name of the user that executed it
+	DBName      string `json:"db_name"`     // name of the database in which it was executed
+}
+
+// Constraint represents a primary key, unique or foreign key constraint from
+// pg_constraint. Added in schema 1.11.
+type Constraint struct {
+	OID        int      `json:"oid"`
+	DBName     string   `json:"db_name"`
+	SchemaName string   `json:"schema_name"`
+	TableOID   int      `json:"table_oid"`
+	TableName  string   `json:"table_name"`
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`    // p=primary key, u=unique, f=foreign key
+	Columns    []string `json:"columns"` // constrained columns, in order
+	Definition string   `json:"def"`     // from pg_get_constraintdef
+	// constraint on the partitioned table that this one was cloned from, in
+	// the same database (pg v11+), 0 if none
+	ParentOID int `json:"parent_oid,omitempty"`
+	// following fields are set only for foreign keys
+	RefSchemaName string   `json:"ref_schema_name,omitempty"`
+	RefTableOID   int      `json:"ref_table_oid,omitempty"`
+	RefTableName  string   `json:"ref_table_name,omitempty"`
+	RefColumns    []string `json:"ref_columns,omitempty"`
+}
+
+// HBARule represents a single line from pg_hba.conf, as reported by
+// pg_hba_file_rules. Postgres v10 and above only. Added in schema 1.11.
+type HBARule struct {
+	LineNumber int      `json:"line_number"`
+	Type       string   `json:"type"`      // local, host, hostssl, hostnossl etc.
+	Databases  []string `json:"databases"` // database names or keywords like "all"
+	Users      []string `json:"users"`     // user names, +groups or "all"
+	Address    string   `json:"address,omitempty"`
+	NetMask    string   `json:"netmask,omitempty"`
+	AuthMethod string   `json:"auth_method"`
+	Options    []string `json:"options,omitempty"`
+	Error      string   `json:"error,omitempty"` // set if the line could not be parsed
+}
+
+// Schema represents a schema (namespace) in a database. System schemas are
+// not included. Added in schema 1.11.
+type Schema struct {
+	OID    int    `json:"oid"`
+	DBName string `json:"db_name"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner"`
+	ACL    string `json:"acl,omitempty"` // empty means default privileges
+}
+
+// SecurityDefiner represents a function that is declared SECURITY DEFINER,
+// and therefore runs with the privileges of its owner. Added in schema 1.11.
+type SecurityDefiner struct {
+	OID        int      `json:"oid"`
+	DBName     string   `json:"db_name"`
+	SchemaName string   `json:"schema_name"`
+	Name       string   `json:"name"`
+	Args       string   `json:"args"` // from pg_get_function_identity_arguments
+	Owner      string   `json:"owner"`
+	Config     []string `json:"config,omitempty"` // "name=value", from proconfig
+	ACL        string   `json:"acl,omitempty"`    // empty means default privileges
+}
+
+// SecurityFinding is a single result of the security audit performed by
+// pgmetrics on the collected information. Added in schema 1.11.
+type SecurityFinding struct {
+	Severity string `json:"severity"` // "info", "warning" or "critical"
+	Category string `json:"category"`
+	Object   string `json:"object"` // the role, table, function etc.
+	Detail   string `json:"detail"`
+}
+
+// ReplicationNode is a server that is part of the replication topology of
+// the server that pgmetrics was run against, found by following its upstream
+// and downstream connections. Added in schema 1.11.
+type ReplicationNode struct {
+	Address          string `json:"address"`               // host:port used to connect
+	ServerAddr       string `json:"server_addr,omitempty"` // from inet_server_addr()
+	Upstream         string `json:"upstream,omitempty"`    // Address of the upstream node, if known
+	ClusterName      string `json:"cluster_name,omitempty"`
+	ServerVersion    string `json:"server_version,omitempty"`
+	SystemIdentifier string `json:"sysid,omitempty"`
+	IsInRecovery     bool   `json:"is_in_recovery"`
+	// current WAL LSN for primaries, last replayed LSN for standbys
+	LSN                     string `json:"lsn,omitempty"`
+	LastXActReplayTimestamp int64  `json:"last_xact_replay_timestamp,omitempty"`
+	At                      int64  `json:"at"` // when the node was collected
+
+	ReplicationOutgoing []ReplicationOut `json:"replication_outgoing,omitempty"`
+	ReplicationIncoming *ReplicationIn   `json:"replication_incoming,omitempty"`
+
+	Error string `json:"error,omitempty"` // set if the node could not be collected
+}
+
+// BlockingNode is a backend in a tree of backends waiting on locks. The root
+// of each tree is a backend that blocks others but is not itself waiting for
+// a lock. Added in schema 1.11.
+type BlockingNode struct {
+	PID             int    `json:"pid"`
+	DBName          string `json:"db_name"`
+	RoleName        string `json:"role_name"`
+	ApplicationName string `json:"application_name"`
+	ClientAddr      string `json:"client_addr"`
+	State           string `json:"state"`
+	WaitEventType   string `json:"wait_event_type"`
+	WaitEvent       string `json:"wait_event"`
+	XactStart       int64  `json:"xact_start"`
+	StateChange     int64  `json:"state_change"`
+	Query           string `json:"query"`
+	// the lock that this backend is waiting for, empty for roots
+	Lock string `json:"lock,omitempty"`
+	// backends that are waiting for this backend
+	Blocked []BlockingNode `json:"blocked,omitempty"`
+	// number of backends directly or indirectly waiting for this backend
+	TotalBlocked int `json:"total_blocked"`
+}
+
+// ActivitySamples is the result of repeatedly sampling the non-idle backends
+// from pg_stat_activity and the locks they are waiting for from pg_locks, over
+// a period of time. Samples are aggregated by the values of the columns that
+// identify what the backend was doing. Added in schema 1.11.
+type ActivitySamples struct {
+	Start    int64                `json:"start"`    // when the first sample was taken
+	End      int64                `json:"end"`      // when the last sample was taken
+	Interval float64              `json:"interval"` // seconds between samples
+	Samples  int                  `json:"samples"`  // number of samples taken
+	Items    []ActivitySampleItem `json:"items,omitempty"`
+}
+
+// ActivitySampleItem is the number of times that backends were seen with a
+// particular combination of values. Added in schema 1.11.
+type ActivitySampleItem struct {
+	DBName          string `json:"db_name"`
+	RoleName        string `json:"role_name"`
+	ApplicationName string `json:"application_name"`
+	State           string `json:"state"`
+	WaitEventType   string `json:"wait_event_type"`
+	WaitEvent       string `json:"wait_event"`
+	Query           string `json:"query"`
+	// the type and mode of the lock that was being waited for, if any
+	LockType string `json:"locktype,omitempty"`
+	LockMode string `json:"lock_mode,omitempty"`
+	Count    int    `json:"count"`
+}
+
+// StatementsInterval is the difference between two snapshots of
+// pg_stat_statements taken some time apart. Only the counters are diffed, the
+// min, max and stddev fields of the statements are not set. Added in
+// schema 1.11.
+type StatementsInterval struct {
+	Start    int64   `json:"start"`    // when the first snapshot was taken
+	End      int64   `json:"end"`      // when the second snapshot was taken
+	Duration float64 `json:"duration"` // seconds between the snapshots
+
+	// the statements that were executed during the interval that are in the
+	// top (as per the statements limit) by calls, time, rows, shared blocks
+	// read, temp blocks or WAL bytes, ordered by time
+	Statements []Statement `json:"statements,omitempty"`
+	// sum over all statements that were executed during the interval
+	Totals Statement `json:"totals"`
+	// number of statements that were executed during the interval
+	Executed int `json:"executed"`
+
+	// number of entries in pg_stat_statements in the first and second
+	// snapshots, and how many of them were added or removed in between
+	Entries1 int `json:"entries1"`
+	Entries2 int `json:"entries2"`
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	// value of pg_stat_statements.max, 0 if not known
+	Max int `json:"max"`
+	// number of times entries were deallocated during the interval, from
+	// pg_stat_statements_info (pg v14+), -1 if not available
+	Dealloc int64 `json:"dealloc"`
+}
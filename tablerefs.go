@@ -0,0 +1,239 @@
+/*
+ * Copyright 2020 RapidLoop, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pgmetrics
+
+import "strings"
+
+// TableRef is a reference to a table (or view, sequence etc.) in an SQL
+// query. Schema is empty if the name was not qualified in the query.
+type TableRef struct {
+	Schema string
+	Name   string
+}
+
+// keywords that start a table reference
+var tableRefStart = map[string]bool{
+	"from": true, "join": true, "update": true, "into": true, "table": true,
+	"truncate": true, "copy": true, "using": true,
+}
+
+// keywords that may follow a table reference, and hence are not aliases
+var tableRefNotAlias = map[string]bool{
+	"where": true, "join": true, "inner": true, "left": true, "right": true,
+	"full": true, "cross": true, "natural": true, "on": true, "using": true,
+	"group": true, "order": true, "limit": true, "offset": true, "union": true,
+	"intersect": true, "except": true, "having": true, "window": true,
+	"for": true, "set": true, "returning": true, "values": true,
+	"select": true, "fetch": true, "tablesample": true, "default": true,
+	"do": true, "when": true, "then": true, "as": true, "overriding": true,
+	"with": true, "from": true, "to": true,
+}
+
+// TableRefs returns the tables referenced in the SQL query, in the order in
+// which they first appear. It is a lightweight scan of the query for names
+// following FROM, JOIN, UPDATE, INTO and the like, and not a full parser.
+// Names of common table expressions (WITH x AS ..) are not included.
+func TableRefs(query string) (refs []TableRef) {
+	tokens := tokenizeSQL(query)
+	ctes := make(map[string]bool)
+	seen := make(map[TableRef]bool)
+
+	// for each open bracket, whether it contains a query (and not, say, the
+	// arguments of a function like EXTRACT(.. FROM ..))
+	inQuery := []bool{true}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch t {
+		case "(":
+			next := tokenAt(tokens, i+1)
+			inQuery = append(inQuery, next == "select" || next == "with" || next == "values")
+			if isCTEName(tokens, i-1) {
+				ctes[unquoteIdent(tokens[i-1])] = true
+			}
+			continue
+		case ")":
+			if len(inQuery) > 1 {
+				inQuery = inQuery[:len(inQuery)-1]
+			}
+			continue
+		case "as":
+			if isCTEName(tokens, i-1) {
+				ctes[unquoteIdent(tokens[i-1])] = true
+			}
+			continue
+		}
+		if !tableRefStart[t] || !inQuery[len(inQuery)-1] {
+			continue
+		}
+		prev := tokenAt(tokens, i-1)
+		switch {
+		case t == "from" && prev == "distinct": // IS [NOT] DISTINCT FROM
+			continue
+		case t == "update" && (prev == "for" || prev == "do" || prev == "key"):
+			continue
+		case t == "from" && tokens[0] == "copy" && len(inQuery) == 1: // COPY .. FROM file
+			continue
+		}
+		// FROM, USING and TRUNCATE can have a list of tables
+		list := t == "from" || t == "using" || t == "truncate"
+		for j := i + 1; j < len(tokens); {
+			for j < len(tokens) && (tokens[j] == "only" || tokens[j] == "lateral" ||
+				(t == "truncate" && tokens[j] == "table") ||
+				(t == "table" && (tokens[j] == "if" || tokens[j] == "not" || tokens[j] == "exists"))) {
+				j++
+			}
+			ref, n := parseTableName(tokens, j)
+			if n == 0 || tokenAt(tokens, j+n) == "(" && t != "into" && t != "copy" {
+				break // not a name, or a function call
+			}
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+			j += n
+			if !list {
+				break
+			}
+			// skip the alias, if any, with optional column aliases
+			if tokenAt(tokens, j) == "as" {
+				j++
+			}
+			if a := tokenAt(tokens, j); isIdentToken(a) && !tableRefNotAlias[a] {
+				j++
+				if tokenAt(tokens, j) == "(" {
+					j = skipBrackets(tokens, j)
+				}
+			}
+			if tokenAt(tokens, j) != "," {
+				break
+			}
+			j++
+		}
+	}
+
+	// remove references to the common table expressions
+	if len(ctes) > 0 {
+		out := refs[:0]
+		for _, r := range refs {
+			if len(r.Schema) > 0 || !ctes[r.Name] {
+				out = append(out, r)
+			}
+		}
+		refs = out
+	}
+	return
+}
+
+func tokenAt(tokens []string, i int) string {
+	if i < 0 || i >= len(tokens) {
+		return ""
+	}
+	return tokens[i]
+}
+
+// isIdentToken checks if the token is an identifier or a keyword.
+func isIdentToken(t string) bool {
+	if len(t) == 0 {
+		return false
+	}
+	return t[0] == '"' || t[0] == '_' || (t[0] >= 'a' && t[0] <= 'z') || t[0] >= 0x80
+}
+
+// unquoteIdent returns the name in a quoted identifier token.
+func unquoteIdent(t string) string {
+	if len(t) >= 2 && t[0] == '"' && t[len(t)-1] == '"' {
+		return strings.ReplaceAll(t[1:len(t)-1], `""`, `"`)
+	}
+	return t
+}
+
+// isCTEName checks if tokens[i] is the name of a common table expression,
+// that is, it follows WITH [RECURSIVE] or a comma within a WITH clause, and
+// is followed by AS or a column list.
+func isCTEName(tokens []string, i int) bool {
+	if !isIdentToken(tokenAt(tokens, i)) {
+		return false
+	}
+	switch tokenAt(tokens, i-1) {
+	case "with", "recursive":
+	case ",":
+		// a comma is ambiguous, so require "as (" or "as [not] materialized"
+		j := i + 1
+		if tokenAt(tokens, j) == "(" {
+			j = skipBrackets(tokens, j)
+		}
+		if tokenAt(tokens, j) != "as" {
+			return false
+		}
+		next := tokenAt(tokens, j+1)
+		return next == "(" || next == "materialized" || next == "not"
+	default:
+		return false
+	}
+	next := tokenAt(tokens, i+1)
+	return next == "as" || next == "("
+}
+
+// skipBrackets returns the index after the bracket that closes the one at
+// tokens[i].
+func skipBrackets(tokens []string, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// parseTableName parses a possibly qualified name (name, schema.name or
+// db.schema.name) starting at tokens[i], and returns the number of tokens
+// consumed, which is 0 if there is no name.
+func parseTableName(tokens []string, i int) (ref TableRef, n int) {
+	var parts []string
+	for {
+		t := tokenAt(tokens, i+n)
+		if !isIdentToken(t) || (len(parts) == 0 && tableRefNotAlias[t]) {
+			if len(parts) > 0 {
+				n-- // don't consume the trailing dot
+			}
+			break
+		}
+		parts = append(parts, unquoteIdent(t))
+		n++
+		if tokenAt(tokens, i+n) != "." {
+			break
+		}
+		n++
+	}
+	switch len(parts) {
+	case 0:
+		return TableRef{}, 0
+	case 1:
+		ref.Name = parts[0]
+	default:
+		ref.Schema, ref.Name = parts[len(parts)-2], parts[len(parts)-1]
+	}
+	return
+}